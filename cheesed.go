@@ -1,23 +1,366 @@
 package main
 
 import (
+	"crypto"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/benburkert/cheeseman/pki"
 	"github.com/benburkert/cheeseman/server"
 )
 
 var (
-	configFile = flag.String("c", "", "Config file.")
+	Version = "dev"
+
+	commands = map[string]func(args []string) int{
+		"serve":    serve,
+		"check":    check,
+		"inspect":  inspect,
+		"version":  version,
+		"reload":   reload,
+		"status":   status,
+		"shutdown": shutdown,
+		"gen-cert": genCert,
+	}
+)
+
+// exitUsage is reserved for bad command lines; config and runtime errors
+// exit with exitError.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
 )
 
 func main() {
-	if *configFile == "" {
-		panic("Missing config file (-c) argument.")
+	args := os.Args[1:]
+
+	// "cheesed -c cheesed.ini" serves, as it always has
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	os.Exit(command(args))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cheesed <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  serve     run the proxy (default)")
+	fmt.Fprintln(os.Stderr, "  check     validate the config and its certificates")
+	fmt.Fprintln(os.Stderr, "  inspect   show what a server name would be served")
+	fmt.Fprintln(os.Stderr, "  reload    reload a running proxy")
+	fmt.Fprintln(os.Stderr, "  status    show a running proxy's listener, adapter and connections")
+	fmt.Fprintln(os.Stderr, "  shutdown  stop a running proxy after draining connections")
+	fmt.Fprintln(os.Stderr, "  gen-cert  generate a certificate and key")
+	fmt.Fprintln(os.Stderr, "  version   print the version")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Every [cheesed] setting can be given as -<key> or CHEESED_<KEY>.")
+	fmt.Fprintln(os.Stderr, "Flags take precedence over the environment, which takes precedence over the config file.")
+}
+
+// configFlags registers -c and a flag for every setting on flags. The
+// returned function loads the config once flags are parsed.
+func configFlags(flags *flag.FlagSet) func() (*server.Config, error) {
+	configFile := flags.String("c", os.Getenv("CHEESED_CONFIG"), "Config file.")
+
+	for _, setting := range server.Settings {
+		flags.String(setting.Key, "", setting.Usage)
+	}
+
+	return func() (*server.Config, error) {
+		overrides := make(map[string]string)
+
+		for _, setting := range server.Settings {
+			if value, ok := os.LookupEnv("CHEESED_" + strings.ToUpper(setting.Key)); ok {
+				overrides[setting.Key] = value
+			}
+		}
+
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name != "c" {
+				overrides[f.Name] = f.Value.String()
+			}
+		})
+
+		config := server.NewConfig()
+
+		if err := config.LoadWith(*configFile, overrides); err != nil {
+			return nil, err
+		}
+
+		return config, nil
+	}
+}
+
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	config, err := load()
+	if err == nil {
+		err = config.Verify()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if err = server.NewServer(config).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	return exitOK
+}
+
+func check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	config, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	report := server.Check(config)
+	report.WriteTo(os.Stdout)
+
+	if report.HasErrors() {
+		return exitError
+	}
+
+	return exitOK
+}
+
+func inspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	load := configFlags(flags)
+	protos := flags.String("alpn", "", "ALPN protocols offered by the client.")
+	schemes := flags.String("sigalgs", "", "Signature schemes supported by the client.")
+	local := flags.String("local", "", "Local address the client connected to.")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: cheesed inspect [flags] <servername>")
+		return exitUsage
+	}
+
+	config, err := load()
+	if err == nil {
+		err = config.Verify()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	hello, err := server.InspectHello(flags.Arg(0), *protos, *schemes, *local)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	selection, err := server.Inspect(config, hello)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	selection.WriteTo(os.Stdout)
+
+	return exitOK
+}
+
+func version(args []string) int {
+	fmt.Println("cheesed " + Version)
+
+	return exitOK
+}
+
+func reload(args []string) int {
+	flags := flag.NewFlagSet("reload", flag.ExitOnError)
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	address, code := controlAddress(load)
+	if code != exitOK {
+		return code
 	}
 
-	config, _ := server.LoadConfig(*configFile)
+	if err := server.AdminCall(address, "POST", "/reload", nil); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	return exitOK
+}
+
+func status(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	address, code := controlAddress(load)
+	if code != exitOK {
+		return code
+	}
+
+	var st server.Status
+	var conns []*server.Connection
+
+	err := server.AdminCall(address, "GET", "/status", &st)
+	if err == nil {
+		err = server.AdminCall(address, "GET", "/connections", &conns)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	for _, listener := range st.Listeners {
+		fmt.Printf("listener:     %s %s (unknownsni: %s)\n", listener.Network, listener.Address, listener.UnknownSNI)
+	}
+
+	fmt.Printf("started:      %s\n", st.Started.Format(time.RFC3339))
+	fmt.Printf("adapter:      %s (reloadable: %t, mutable: %t, certificates: %d)\n", st.Adapter.Name, st.Adapter.Reloadable, st.Adapter.Mutable, st.Adapter.Certificates)
+
+	if len(st.Adapter.Protocols) > 0 {
+		fmt.Printf("protocols:    %s\n", strings.Join(st.Adapter.Protocols, ", "))
+	}
+
+	if cache := st.Adapter.Cache; cache != nil {
+		fmt.Printf("cache:        %d hits, %d negative hits, %d misses, %d errors\n", cache.Hits, cache.NegativeHits, cache.Misses, cache.Errors)
+	}
+
+	if signer := st.Signer; signer != nil {
+		fmt.Printf("signer:       %d calls, %d errors, %d timeouts\n", signer.Calls, signer.Errors, signer.Timeouts)
+	}
+
+	fmt.Printf("connections:  %d\n", len(conns))
+
+	for _, conn := range conns {
+		fmt.Printf("  %s -> %s  %s  %s  since %s\n", conn.Remote, conn.Local, conn.ServerName, strings.Join(conn.Backends, ","), conn.Since.Format(time.RFC3339))
+	}
+
+	return exitOK
+}
+
+func shutdown(args []string) int {
+	flags := flag.NewFlagSet("shutdown", flag.ExitOnError)
+	load := configFlags(flags)
+	grace := flags.Duration("grace", 30*time.Second, "Time to let connections finish.")
+	flags.Parse(args)
+
+	address, code := controlAddress(load)
+	if code != exitOK {
+		return code
+	}
+
+	if err := server.AdminCall(address, "POST", "/shutdown?grace="+grace.String(), nil); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
 
-	server := server.NewServer(config)
+	return exitOK
+}
+
+// controlAddress prefers the control socket and falls back to the admin API.
+func controlAddress(load func() (*server.Config, error)) (string, int) {
+	config, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return "", exitError
+	}
+
+	if config.Control != "" {
+		return config.Control, exitOK
+	}
+
+	if config.Admin != "" {
+		return config.Admin, exitOK
+	}
+
+	fmt.Fprintln(os.Stderr, "No control socket (-control) or admin address (-admin) configured.")
+	return "", exitUsage
+}
+
+func genCert(args []string) int {
+	flags := flag.NewFlagSet("gen-cert", flag.ExitOnError)
+	hosts := flags.String("hosts", "", "Host names and addresses for the certificate.")
+	caFile := flags.String("ca", "", "CA certificate to sign with; self-signed if empty.")
+	caKeyFile := flags.String("cakey", "", "CA private key.")
+	isCA := flags.Bool("isca", false, "Generate a CA certificate.")
+	keyType := flags.String("keytype", pki.KeyTypeECDSA, "Key type: ecdsa or rsa.")
+	lifetime := flags.Duration("lifetime", 90*24*time.Hour, "Certificate lifetime.")
+	out := flags.String("out", "cert", "Output prefix for <out>.crt and <out>.key.")
+	flags.Parse(args)
+
+	names := server.SplitList(*hosts)
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "gen-cert requires -hosts.")
+		return exitUsage
+	}
+
+	cert, key, err := generate(names, *caFile, *caKeyFile, *isCA, *keyType, *lifetime)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	keyPEM, err := pki.EncodeKey(key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if err = ioutil.WriteFile(*out+".key", keyPEM, 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if err = ioutil.WriteFile(*out+".crt", pki.EncodeCertificate(cert), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	fmt.Println("Wrote " + *out + ".crt and " + *out + ".key")
+
+	return exitOK
+}
+
+func generate(names []string, caFile, caKeyFile string, isCA bool, keyType string, lifetime time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	if isCA {
+		return pki.GenerateCA(names[0], keyType, lifetime)
+	}
+
+	if caFile == "" {
+		return pki.GenerateCert(names, keyType, lifetime, nil, nil)
+	}
+
+	ca, caKey, err := pki.LoadPair(caFile, caKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	server.Run()
+	return pki.GenerateCert(names, keyType, lifetime, ca, caKey)
 }
@@ -0,0 +1,214 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+)
+
+type Error struct {
+	message string
+}
+
+const (
+	KeyTypeRSA   = "rsa"
+	KeyTypeECDSA = "ecdsa"
+
+	RSAKeySize = 2048
+)
+
+var (
+	maxSerial = new(big.Int).Lsh(big.NewInt(1), 128)
+	clockSkew = 5 * time.Minute
+)
+
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, RSAKeySize)
+	case KeyTypeECDSA, "":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	return nil, newError("Unknown key type: " + keyType)
+}
+
+func NewTemplate(hostnames []string, lifetime time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, maxSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Cheeseman"},
+		},
+
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-clockSkew).UTC(),
+		NotAfter:     time.Now().Add(lifetime).UTC(),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if len(hostnames) > 0 {
+		template.Subject.CommonName = hostnames[0]
+	}
+
+	for _, hostname := range hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	return template, nil
+}
+
+func NewCATemplate(hostname string, lifetime time.Duration) (*x509.Certificate, error) {
+	template, err := NewTemplate([]string{hostname}, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	template.ExtKeyUsage = nil
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.BasicConstraintsValid = true
+	template.IsCA = true
+
+	return template, nil
+}
+
+// CreateCertificate signs template with parentKey. A nil parent self-signs
+// the certificate with key.
+func CreateCertificate(template, parent *x509.Certificate, key, parentKey crypto.Signer) (*x509.Certificate, error) {
+	if template.SubjectKeyId == nil {
+		keyId, err := subjectKeyId(key.Public())
+		if err != nil {
+			return nil, err
+		}
+
+		template.SubjectKeyId = keyId
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+		template.AuthorityKeyId = template.SubjectKeyId
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func GenerateCA(hostname, keyType string, lifetime time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	key, err := GenerateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template, err := NewCATemplate(hostname, lifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := CreateCertificate(template, nil, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func GenerateCert(hostnames []string, keyType string, lifetime time.Duration, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	key, err := GenerateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template, err := NewTemplate(hostnames, lifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if parent != nil && template.NotAfter.After(parent.NotAfter) {
+		template.NotAfter = parent.NotAfter
+	}
+
+	cert, err := CreateCertificate(template, parent, key, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func LoadPair(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, newError("Unsupported private key in " + keyPath)
+	}
+
+	return cert, key, nil
+}
+
+func EncodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func EncodeKey(key crypto.Signer) ([]byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	}
+
+	return nil, newError("Unsupported private key type")
+}
+
+func subjectKeyId(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(der)
+	return sum[:], nil
+}
+
+func newError(message string) error {
+	return Error{message: message}
+}
+
+func (err Error) Error() string {
+	return err.message
+}
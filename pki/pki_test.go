@@ -0,0 +1,51 @@
+package pki
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestGenerateCert(t *testing.T) {
+	ca, caKey, err := GenerateCA("ca.example.com", KeyTypeRSA, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	if !ca.IsCA || ca.PublicKey.(*rsa.PublicKey).N.BitLen() != RSAKeySize {
+		t.Fatal("CA certificate is not a production grade CA")
+	}
+
+	cert, key, err := GenerateCert([]string{"foo.example.com", "127.0.0.1"}, KeyTypeECDSA, 2*time.Hour, ca, caKey)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	if cert.NotAfter.After(ca.NotAfter) {
+		t.Fatal("Certificate outlives its CA")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	for _, name := range []string{"foo.example.com", "127.0.0.1"} {
+		if _, err = cert.Verify(x509.VerifyOptions{DNSName: name, Roots: pool}); err != nil {
+			t.Fatalf("Error verifying certificate for %s: %s", name, err.Error())
+		}
+	}
+
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %s", err.Error())
+	}
+
+	if _, err = tls.X509KeyPair(EncodeCertificate(cert), keyPEM); err != nil {
+		t.Fatalf("Encoded pair did not load: %s", err.Error())
+	}
+
+	if _, err = GenerateKey("dsa"); err == nil {
+		t.Fatal("GenerateKey did not catch an unknown key type")
+	}
+}
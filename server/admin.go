@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benburkert/cheeseman/sni"
+)
+
+// The admin API is served on a unix socket or a loopback address:
+//
+//	GET    /hosts        host names with their certificates
+//	GET    /hosts/<name> one host
+//	PUT    /hosts/<name> add or replace a certificate, as {"chain","key"} JSON or PEM
+//	DELETE /hosts/<name> remove a certificate
+//	POST   /reload       reload the adapter and ticket keys
+//	GET    /connections  proxied connections
+//	GET    /status       listener, adapter and connection status
+//	POST   /shutdown     stop accepting and drain connections
+type adminHost struct {
+	Name        string    `json:"name"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	DNSNames    []string  `json:"dnsnames,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	NotBefore   time.Time `json:"notbefore,omitempty"`
+	NotAfter    time.Time `json:"notafter,omitempty"`
+	Backends    []string  `json:"backends,omitempty"`
+	Certificate bool      `json:"certificate"`
+}
+
+type adminCertificate struct {
+	Chain string `json:"chain"`
+	Key   string `json:"key"`
+}
+
+type Connection struct {
+	Remote     string    `json:"remote"`
+	Local      string    `json:"local"`
+	ServerName string    `json:"servername"`
+	Known      bool      `json:"known"`
+	Backends   []string  `json:"backends"`
+	Since      time.Time `json:"since"`
+}
+
+var (
+	maxAdminBody int64 = 1 << 20
+)
+
+func listenAdmin(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "unix:") {
+		return listenUnix(strings.TrimPrefix(address, "unix:"))
+	}
+
+	return net.Listen("tcp", address)
+}
+
+func verifyAdminAddress(address string) error {
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "unix:") {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return _error("Admin address must be a unix socket or loopback address: " + address)
+}
+
+func (srv *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hosts", srv.adminHosts)
+	mux.HandleFunc("/hosts/", srv.adminHost)
+	mux.HandleFunc("/reload", srv.adminReload)
+	mux.HandleFunc("/connections", srv.adminConnections)
+	mux.HandleFunc("/status", srv.adminStatus)
+	mux.HandleFunc("/shutdown", srv.adminShutdown)
+
+	return mux
+}
+
+func (srv *Server) adminHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	var certs map[string]*tls.Certificate
+	if enumerator, ok := srv.sniAdapter.(sni.Enumerator); ok {
+		certs = enumerator.Certificates()
+	}
+
+	names := make(map[string]bool)
+	for name := range certs {
+		names[name] = true
+	}
+	for name := range srv.hosts {
+		names[name] = true
+	}
+
+	hosts := make([]*adminHost, 0, len(names))
+	for name := range names {
+		hosts = append(hosts, srv.adminHostInfo(name, certs[name]))
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+
+	adminJSON(w, http.StatusOK, hosts)
+}
+
+func (srv *Server) adminHost(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/hosts/"))
+	if name == "" || strings.Contains(name, "/") {
+		adminError(w, http.StatusNotFound, "Unknown host "+name)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var cert *tls.Certificate
+		if enumerator, ok := srv.sniAdapter.(sni.Enumerator); ok {
+			cert = enumerator.Certificates()[name]
+		}
+
+		if _, ok := srv.hosts[name]; cert == nil && !ok {
+			adminError(w, http.StatusNotFound, "Unknown host "+name)
+			return
+		}
+
+		adminJSON(w, http.StatusOK, srv.adminHostInfo(name, cert))
+	case "PUT":
+		mutator, ok := srv.sniAdapter.(sni.Mutator)
+		if !ok {
+			adminError(w, http.StatusNotImplemented, "Adapter cannot be changed at runtime")
+			return
+		}
+
+		cert, err := srv.adminReadCertificate(r)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err = mutator.SetCertificate(name, cert); err != nil {
+			adminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		srv.trackStaples()
+
+		adminJSON(w, http.StatusOK, srv.adminHostInfo(name, cert))
+	case "DELETE":
+		mutator, ok := srv.sniAdapter.(sni.Mutator)
+		if !ok {
+			adminError(w, http.StatusNotImplemented, "Adapter cannot be changed at runtime")
+			return
+		}
+
+		if err := mutator.RemoveCertificate(name); err != nil {
+			adminError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		srv.trackStaples()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		adminError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed")
+	}
+}
+
+func (srv *Server) adminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		adminError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	if err := srv.Reload(); err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	adminJSON(w, http.StatusOK, srv.connectionList())
+}
+
+func (srv *Server) connectionList() []*Connection {
+	srv.routesLock.Lock()
+	defer srv.routesLock.Unlock()
+
+	conns := make([]*Connection, 0, len(srv.routes))
+	for conn, rt := range srv.routes {
+		conns = append(conns, &Connection{
+			Remote:     conn.RemoteAddr().String(),
+			Local:      conn.LocalAddr().String(),
+			ServerName: rt.serverName,
+			Known:      rt.known,
+			Backends:   rt.backends,
+			Since:      rt.since,
+		})
+	}
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Since.Before(conns[j].Since) })
+
+	return conns
+}
+
+func (srv *Server) adminHostInfo(name string, cert *tls.Certificate) *adminHost {
+	host := &adminHost{Name: name, Certificate: cert != nil}
+
+	if config, ok := srv.hosts[name]; ok {
+		host.Backends = config.Backends
+	}
+
+	if cert == nil || len(cert.Certificate) == 0 {
+		return host
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return host
+		}
+	}
+
+	host.Subject = leaf.Subject.String()
+	host.Issuer = leaf.Issuer.String()
+	host.DNSNames = leaf.DNSNames
+	host.Serial = leaf.SerialNumber.Text(16)
+	host.NotBefore = leaf.NotBefore
+	host.NotAfter = leaf.NotAfter
+
+	return host
+}
+
+func (srv *Server) adminReadCertificate(r *http.Request) (*tls.Certificate, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxAdminBody))
+	if err != nil {
+		return nil, err
+	}
+
+	chain, key := body, body
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var payload adminCertificate
+
+		if err = json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+
+		chain, key = []byte(payload.Chain), []byte(payload.Key)
+	}
+
+	cert, err := srv.keys.X509KeyPair(chain, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+func adminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+func adminError(w http.ResponseWriter, status int, message string) {
+	adminJSON(w, status, map[string]string{"error": message})
+}
+
+// AdminCall makes a request to the admin API at address and decodes the JSON
+// response into v, if v is not nil.
+func AdminCall(address, method, path string, v interface{}) error {
+	network, addr := "tcp", address
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(address, "unix:")
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	req, err := http.NewRequest(method, "http://cheesed"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure map[string]string
+		json.NewDecoder(resp.Body).Decode(&failure)
+
+		if failure["error"] != "" {
+			return _error(failure["error"])
+		}
+
+		return _error(method + " " + path + ": " + resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestAdminHosts(t *testing.T) {
+	config := testConfig(t)
+	config.Admin = filepath.Join(filepath.Dir(config.Address), "admin.sock")
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+	config.Backends = []string{echoBackend(t)}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	client := adminClient(config.Admin)
+
+	var hosts []*adminHost
+	adminRequest(client, "GET", "/hosts", nil, http.StatusOK, &hosts, t)
+
+	if len(hosts) != 1 || hosts[0].Name != "foo.example.org" || hosts[0].Subject == "" {
+		t.Fatalf("Unexpected hosts: %+v", hosts)
+	}
+
+	cert, key, err := pki.GenerateCert([]string{"bar.example.org"}, pki.KeyTypeECDSA, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+	body, _ := json.Marshal(adminCertificate{Chain: string(pki.EncodeCertificate(cert)), Key: string(keyPEM)})
+
+	adminRequest(client, "PUT", "/hosts/bar.example.org", body, http.StatusOK, nil, t)
+
+	var host adminHost
+	adminRequest(client, "GET", "/hosts/bar.example.org", nil, http.StatusOK, &host, t)
+
+	if host.Subject == "" {
+		t.Fatalf("Unexpected host: %+v", host)
+	}
+
+	if info, err := os.Stat(config.Admin); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Admin socket is not private: %v", err)
+	}
+
+	cli := insecureClient(config, "bar.example.org", t)
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Error during handshake: %s", err.Error())
+	}
+
+	if cn := cli.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "bar.example.org" {
+		t.Fatalf("Runtime certificate was not served: %s", cn)
+	}
+
+	var conns []*Connection
+	adminRequest(client, "GET", "/connections", nil, http.StatusOK, &conns, t)
+
+	if len(conns) != 1 || conns[0].ServerName != "bar.example.org" || !conns[0].Known {
+		t.Fatalf("Unexpected connections: %+v", conns)
+	}
+	cli.Close()
+
+	adminRequest(client, "DELETE", "/hosts/bar.example.org", nil, http.StatusNoContent, nil, t)
+	adminRequest(client, "GET", "/hosts/bar.example.org", nil, http.StatusNotFound, nil, t)
+	adminRequest(client, "POST", "/reload", nil, http.StatusNoContent, nil, t)
+	adminRequest(client, "PUT", "/hosts/baz.example.org", []byte("garbage"), http.StatusBadRequest, nil, t)
+}
+
+func TestAdminAddress(t *testing.T) {
+	for _, address := range []string{"/run/cheesed/admin.sock", "127.0.0.1:9000", "[::1]:9000", "localhost:9000"} {
+		if err := verifyAdminAddress(address); err != nil {
+			t.Fatalf("Rejected admin address %s: %s", address, err.Error())
+		}
+	}
+
+	if err := verifyAdminAddress("0.0.0.0:9000"); err == nil {
+		t.Fatal("Accepted a non-loopback admin address")
+	}
+}
+
+func adminClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func adminRequest(client *http.Client, method, path string, body []byte, status int, v interface{}, t *testing.T) {
+	req, err := http.NewRequest(method, "http://cheesed"+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling admin API: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s returned %s, expected %d", method, path, resp.Status, status)
+	}
+
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Error decoding response: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	backendDialTimeout = 5 * time.Second
+)
+
+func dialBackend(backends []string) (conn net.Conn, err error) {
+	for _, backend := range backends {
+		network, address := backendAddr(backend)
+
+		conn, err = net.DialTimeout(network, address, backendDialTimeout)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	if err == nil {
+		err = _error("No backends configured")
+	}
+
+	return nil, err
+}
+
+func backendAddr(backend string) (network, address string) {
+	if strings.HasPrefix(backend, "unix:") {
+		return "unix", strings.TrimPrefix(backend, "unix:")
+	}
+
+	if strings.HasPrefix(backend, "/") {
+		return "unix", backend
+	}
+
+	return "tcp", backend
+}
+
+func proxy(client net.Conn, backends []string) error {
+	backend, err := dialBackend(backends)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	done := make(chan error, 2)
+
+	go func() {
+		_, err := io.Copy(backend, client)
+		closeWrite(backend)
+		done <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(client, backend)
+		closeWrite(client)
+		done <- err
+	}()
+
+	err = <-done
+	if err2 := <-done; err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface {
+		CloseWrite() error
+	}); ok {
+		cw.CloseWrite()
+	}
+}
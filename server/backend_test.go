@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/benburkert/cheeseman/signer"
+	"github.com/benburkert/cheeseman/sni"
+	"github.com/benburkert/cheeseman/test"
+)
+
+func TestHostBackend(t *testing.T) {
+	config := testConfig(t)
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+	config.Hosts["foo.example.org"] = &HostConfig{
+		Name:     "foo.example.org",
+		Policy:   new(Policy),
+		Backends: []string{echoBackend(t)},
+	}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	assertEcho(insecureClient(config, "foo.example.org", t), t)
+}
+
+func TestUnknownSNIReject(t *testing.T) {
+	config := testConfig(t)
+	config.UnknownSNI = UnknownSNIReject
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	cli := insecureClient(config, "unknown.example.org", t)
+	defer cli.Close()
+
+	err := cli.Handshake()
+	if err == nil || !strings.Contains(err.Error(), "unrecognized name") {
+		t.Fatalf("Expected an unrecognized_name alert, got: %v", err)
+	}
+}
+
+func TestUnknownSNIFallback(t *testing.T) {
+	config := testConfig(t)
+	config.UnknownSNI = UnknownSNIFallback
+	config.Fallback = []string{echoBackend(t)}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	assertEcho(insecureClient(config, "unknown.example.org", t), t)
+}
+
+func TestUnknownSNIDestination(t *testing.T) {
+	config := testConfig(t)
+	config.Type = "tcp"
+	config.Address = "127.0.0.1:0"
+	config.UnknownSNI = UnknownSNIDestination
+	config.Destinations["127.0.0.1"] = "foo.example.org"
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	conn, err := net.Dial("tcp", srv.listener.inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Error establishing client connection: %s", err.Error())
+	}
+
+	cli := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	defer cli.Close()
+
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Error during handshake: %s", err.Error())
+	}
+
+	cn := cli.ConnectionState().PeerCertificates[0].Subject.CommonName
+	if cn != "foo.example.org" {
+		t.Fatalf("Destination address selected the wrong certificate: %s", cn)
+	}
+}
+
+func testHostPair(hostname string, t *testing.T) string {
+	caCert, caKey, err := test.GenerateCAPair("ca.example.org")
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	cert, key, err := test.GenerateCertPair(hostname, caCert, caKey)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	certFile, keyFile, err := test.TempFilePair(cert, key)
+	if err != nil {
+		t.Fatalf("Error writing certificate: %s", err.Error())
+	}
+
+	return certFile + "," + keyFile
+}
+
+func insecureClient(config *Config, servername string, t *testing.T) *tls.Conn {
+	return tls.Client(unixConn(config.Address, t), &tls.Config{
+		ServerName:         servername,
+		InsecureSkipVerify: true,
+	})
+}
+
+func echoBackend(t *testing.T) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error creating echo backend: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return lst.Addr().String()
+}
+
+func assertEcho(cli *tls.Conn, t *testing.T) {
+	defer cli.Close()
+
+	if _, err := cli.Write([]byte("ping")); err != nil {
+		t.Fatalf("Error writing to backend: %s", err.Error())
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(cli, buf); err != nil {
+		t.Fatalf("Error reading from backend: %s", err.Error())
+	}
+
+	if string(buf) != "ping" {
+		t.Fatalf("Unexpected backend response: %q", buf)
+	}
+}
+
+func TestAdapterProtocols(t *testing.T) {
+	config := testConfig(t)
+	config.SNIAdapterName = "protocols"
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+	config.Backends = []string{echoBackend(t)}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	cli := tls.Client(unixConn(config.Address, t), &tls.Config{
+		ServerName:         "foo.example.org",
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Error during handshake: %s", err.Error())
+	}
+
+	if proto := cli.ConnectionState().NegotiatedProtocol; proto != "acme-tls/1" {
+		t.Fatalf("Adapter protocol was not negotiated: %q", proto)
+	}
+
+	if n, _ := cli.Read(make([]byte, 1)); n != 0 {
+		t.Fatal("Adapter protocol connection was proxied to a backend")
+	}
+
+	assertEcho(insecureClient(config, "foo.example.org", t), t)
+}
+
+type protocolsAdapter struct {
+	sni.Adapter
+}
+
+func (adp protocolsAdapter) NextProtos() []string {
+	return []string{"acme-tls/1"}
+}
+
+var _ = sni.Register("protocols", func(config map[string]string) (sni.Adapter, error) {
+	adapter, err := sni.NewInMemoryAdapter(config)
+	return protocolsAdapter{adapter}, err
+})
+
+func TestRemoteSigner(t *testing.T) {
+	caCert, caKey, err := test.GenerateCAPair("ca.example.org")
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	cert, key, err := test.GenerateCertPair("foo.example.org", caCert, caKey)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	certFile, _, err := test.TempFilePair(cert, key)
+	if err != nil {
+		t.Fatalf("Error writing certificate: %s", err.Error())
+	}
+
+	socket := tempFile("", t) + ".sock"
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error creating signer socket: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go signer.Serve(listener, map[string]crypto.Signer{"foo": key})
+
+	config := testConfig(t)
+	config.Signer = socket
+	config.SNIAdapterConfig["foo.example.org"] = certFile + ",signer:foo"
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	cli := insecureClient(config, "foo.example.org", t)
+	defer cli.Close()
+
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Error during handshake: %s", err.Error())
+	}
+
+	if stats := srv.signer.Stats(); stats.Calls == 0 || stats.Errors != 0 {
+		t.Fatalf("Unexpected signer stats: %+v", stats)
+	}
+}
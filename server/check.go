@@ -0,0 +1,214 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benburkert/cheeseman/sni"
+)
+
+type Severity int
+
+const (
+	SeverityOK Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+type Finding struct {
+	Name     string
+	Severity Severity
+	Message  string
+}
+
+type Report struct {
+	Findings []*Finding
+}
+
+var (
+	expiryWarning = 30 * 24 * time.Hour
+)
+
+// Check validates config the way the server would use it: the settings, the
+// default certificate, the adapter and every certificate the adapter can
+// enumerate or that a [host:*] section names.
+func Check(config *Config) *Report {
+	report := new(Report)
+
+	if err := config.Verify(); err != nil {
+		report.add("config", SeverityError, err.Error())
+	}
+
+	adapterConfig, keys := setupKeys(config)
+
+	if config.Certificate == "" {
+		report.add("default", SeverityError, "No default certificate configured")
+	} else if cert, err := keys.LoadX509KeyPair(config.Certificate, config.Key); err != nil {
+		report.add("default", SeverityError, err.Error())
+	} else {
+		report.checkCertificate("default", "", &cert, time.Now())
+	}
+
+	adapter, err := sni.NewAdapter(config.SNIAdapterName, adapterConfig)
+	if err != nil {
+		report.add("adapter "+config.SNIAdapterName, SeverityError, err.Error())
+		return report
+	}
+
+	if closer, ok := adapter.(sni.Closer); ok {
+		defer closer.Close()
+	}
+
+	certs := make(map[string]*tls.Certificate)
+	if enumerator, ok := adapter.(sni.Enumerator); ok {
+		certs = enumerator.Certificates()
+	} else {
+		report.add("adapter "+config.SNIAdapterName, SeverityWarning, "Adapter cannot list its certificates; only [host:*] sections were checked")
+	}
+
+	for name := range config.Hosts {
+		if _, ok := certs[name]; ok {
+			continue
+		}
+
+		// peek so that checking never issues certificates
+		cert, err := sni.Peek(adapter, &tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			report.add(name, SeverityError, err.Error())
+			continue
+		}
+
+		if cert == nil {
+			report.add(name, SeverityWarning, "No certificate issued yet; the default certificate will be served")
+			continue
+		}
+
+		certs[name] = cert
+	}
+
+	names := make([]string, 0, len(certs))
+	for name := range certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		report.checkCertificate(name, name, certs[name], time.Now())
+	}
+
+	return report
+}
+
+func (report *Report) checkCertificate(name, hostname string, cert *tls.Certificate, now time.Time) {
+	if len(cert.Certificate) == 0 {
+		report.add(name, SeverityError, "Empty certificate chain")
+		return
+	}
+
+	chain := make([]*x509.Certificate, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			report.add(name, SeverityError, "Certificate "+strconv.Itoa(i)+" does not parse: "+err.Error())
+			return
+		}
+
+		chain[i] = parsed
+	}
+
+	leaf := chain[0]
+	failed := false
+
+	for i := 1; i < len(chain); i++ {
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			report.add(name, SeverityError, "Chain is out of order at certificate "+strconv.Itoa(i)+": "+err.Error())
+			failed = true
+		}
+	}
+
+	if hostname != "" {
+		probe := hostname
+		if strings.HasPrefix(probe, "*.") {
+			probe = "wildcard" + probe[1:]
+		}
+
+		if err := leaf.VerifyHostname(probe); err != nil {
+			report.add(name, SeverityError, "Certificate does not cover "+hostname+": "+err.Error())
+			failed = true
+		}
+	}
+
+	for _, c := range chain {
+		switch {
+		case now.After(c.NotAfter):
+			report.add(name, SeverityError, "Certificate "+c.Subject.CommonName+" expired "+c.NotAfter.Format(time.RFC3339))
+			failed = true
+		case now.Before(c.NotBefore):
+			report.add(name, SeverityError, "Certificate "+c.Subject.CommonName+" is not valid until "+c.NotBefore.Format(time.RFC3339))
+			failed = true
+		case now.Add(expiryWarning).After(c.NotAfter):
+			report.add(name, SeverityWarning, "Certificate "+c.Subject.CommonName+" expires "+c.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	if failed {
+		return
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates, CurrentTime: now}); err != nil {
+		report.add(name, SeverityWarning, "Chain does not verify against the system roots: "+err.Error())
+	}
+
+	report.add(name, SeverityOK, fmt.Sprintf("%s, %d certificate(s), expires %s", leaf.Subject.CommonName, len(chain), leaf.NotAfter.Format(time.RFC3339)))
+}
+
+func (report *Report) add(name string, severity Severity, message string) {
+	report.Findings = append(report.Findings, &Finding{Name: name, Severity: severity, Message: message})
+}
+
+func (report *Report) HasErrors() bool {
+	for _, finding := range report.Findings {
+		if finding.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (report *Report) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+
+	for _, finding := range report.Findings {
+		written, err := fmt.Fprintf(w, "%-7s %s: %s\n", finding.Severity, finding.Name, finding.Message)
+		n += int64(written)
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityOK:
+		return "ok"
+	case SeverityWarning:
+		return "warning"
+	}
+
+	return "error"
+}
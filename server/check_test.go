@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+	"github.com/benburkert/cheeseman/signer"
+	"github.com/benburkert/cheeseman/test"
+	"github.com/youmark/pkcs8"
+)
+
+func TestCheck(t *testing.T) {
+	config := testConfig(t)
+	config.Certificate, config.Key = splitPair(testHostPair("default.example.org", t))
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+
+	report := Check(config)
+	if report.HasErrors() {
+		t.Fatalf("Unexpected errors in report:\n%s", reportString(report))
+	}
+
+	config.SNIAdapterConfig["bar.example.org"] = testHostPair("foo.example.org", t)
+
+	report = Check(config)
+	if !report.HasErrors() || !strings.Contains(reportString(report), "does not cover bar.example.org") {
+		t.Fatalf("SAN mismatch was not reported:\n%s", reportString(report))
+	}
+
+	config.SNIAdapterConfig = map[string]string{"foo.example.org": "/nonexistent/*.pem"}
+
+	report = Check(config)
+	if !report.HasErrors() || !strings.Contains(reportString(report), "No files match") {
+		t.Fatalf("Missing certificate files were not reported:\n%s", reportString(report))
+	}
+
+	config.SNIAdapterName = "nonexistent"
+
+	if report = Check(config); !report.HasErrors() {
+		t.Fatal("Unknown adapter was not reported")
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	caCert, caKey, err := test.GenerateCAPair("ca.example.org")
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	cert, key, err := test.GenerateCertPair("default.example.org", caCert, caKey)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	certFile, _, err := test.TempFilePair(cert, key)
+	if err != nil {
+		t.Fatalf("Error writing certificate: %s", err.Error())
+	}
+
+	der, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Error encrypting key: %s", err.Error())
+	}
+
+	t.Setenv("CHEESED_TEST_PASSPHRASE", "secret")
+
+	config := testConfig(t)
+	config.Certificate = certFile
+	config.Key = tempFile(string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})), t)
+	config.Passphrase = "env:CHEESED_TEST_PASSPHRASE"
+
+	if report := Check(config); report.HasErrors() {
+		t.Fatalf("Encrypted default key was not checked with the passphrase:\n%s", reportString(report))
+	}
+
+	socket := tempFile("", t) + ".sock"
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error creating signer socket: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go signer.Serve(listener, map[string]crypto.Signer{"default": key})
+
+	config.Key = "signer:default"
+	config.Signer = socket
+	config.SNIAdapterConfig["default.example.org"] = certFile + ",signer:default"
+
+	if report := Check(config); report.HasErrors() {
+		t.Fatalf("Signer key references were not checked with the signer:\n%s", reportString(report))
+	}
+}
+
+func splitPair(pair string) (string, string) {
+	parts := strings.SplitN(pair, ",", 2)
+	return parts[0], parts[1]
+}
+
+func reportString(report *Report) string {
+	var buf bytes.Buffer
+	report.WriteTo(&buf)
+
+	return buf.String()
+}
+
+func TestCheckDoesNotIssue(t *testing.T) {
+	ca, key, err := pki.GenerateCA("ca.test", pki.KeyTypeECDSA, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+	cache := t.TempDir()
+
+	config := testConfig(t)
+	config.Certificate, config.Key = splitPair(testHostPair("default.example.org", t))
+	config.Hosts["app.test"] = &HostConfig{
+		Name:     "app.test",
+		Policy:   new(Policy),
+		Backends: []string{"127.0.0.1:80"},
+	}
+	config.SNIAdapterName = "localca"
+	config.SNIAdapterConfig = map[string]string{
+		"ca":    tempFile(string(pki.EncodeCertificate(ca)), t),
+		"cakey": tempFile(string(keyPEM), t),
+		"hosts": "*.test",
+		"cache": cache,
+	}
+
+	report := Check(config)
+	if !strings.Contains(reportString(report), "app.test: No certificate issued yet") {
+		t.Fatalf("Unissued certificate was not reported:\n%s", reportString(report))
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(cache, "*")); len(files) != 0 {
+		t.Fatalf("Check issued certificates: %v", files)
+	}
+}
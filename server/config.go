@@ -2,9 +2,10 @@ package server
 
 import (
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
-
-	"github.com/glacjay/goini"
+	"time"
 )
 
 type Error struct {
@@ -14,26 +15,111 @@ type Error struct {
 type Config struct {
 	Address          string
 	Type             string
+	Listeners        []*ListenerConfig
 	Certificate      string
 	Key              string
 	Log              string
 	SNIAdapterName   string
 	SNIAdapterConfig map[string]string
+	Policy           *Policy
+	Hosts            map[string]*HostConfig
+	Backends         []string
+	UnknownSNI       string
+	Fallback         []string
+	Destinations     map[string]string
+	TicketKeys       string
+	TicketRotation   time.Duration
+	TicketOverlap    int
+	OCSP             bool
+	OCSPCache        string
+	OCSPResponder    string
+	OCSPMustStaple   bool
+	Signer           string
+	SignerTimeout    time.Duration
+	Passphrase       string
+	Admin            string
+	Control          string
+	State            string
+}
+
+type ListenerConfig struct {
+	Type       string
+	Address    string
+	UnknownSNI string
+}
+
+type HostConfig struct {
+	Name     string
+	Policy   *Policy
+	Backends []string
+}
+
+type Setting struct {
+	Key   string
+	Usage string
 }
 
+// Settings lists the [cheesed] keys. Each can also be given as a command line
+// flag or a CHEESED_<KEY> environment variable.
+var Settings = []Setting{
+	{"address", "Listen address."},
+	{"type", "Listen network: tcp, tcp4, tcp6 or unix."},
+	{"listeners", "Additional listen addresses, as [type:]address [unknownsni=<policy>]."},
+	{"certificate", "Default certificate file or PKCS#12 bundle."},
+	{"key", "Default private key file or key reference."},
+	{"log", "Log file, or stdout."},
+	{"backend", "Backend addresses."},
+	{"unknownsni", "Unknown server name policy: default, reject, fallback or ip."},
+	{"fallback", "Backend addresses for unknown server names."},
+	{"minversion", "Minimum TLS version."},
+	{"maxversion", "Maximum TLS version."},
+	{"ciphersuites", "TLS 1.2 cipher suites."},
+	{"curves", "Key exchange curves."},
+	{"sessiontickets", "Enable session tickets."},
+	{"ticketkeys", "Session ticket key file."},
+	{"ticketrotation", "Session ticket key rotation interval."},
+	{"ticketoverlap", "Previous session ticket keys to accept."},
+	{"ocsp", "Staple OCSP responses."},
+	{"ocspcache", "OCSP response cache directory."},
+	{"ocspresponder", "OCSP responder URL override."},
+	{"ocspmuststaple", "Refuse certificates that must staple without a response."},
+	{"signer", "Remote signer socket."},
+	{"signertimeout", "Remote signer timeout."},
+	{"passphrase", "Passphrase source for encrypted keys."},
+	{"admin", "Admin API socket or loopback address."},
+	{"control", "Control socket for the reload, status and shutdown commands."},
+	{"state", "State directory for runtime changes."},
+	{"sniadapter", "SNI adapter name."},
+}
+
+const (
+	UnknownSNIDefault     = "default"
+	UnknownSNIReject      = "reject"
+	UnknownSNIFallback    = "fallback"
+	UnknownSNIDestination = "ip"
+)
+
 func NewConfig() *Config {
 	return &Config{
 		Address:          "0.0.0.0:443",
 		Type:             "tcp4",
 		Log:              "stdout",
 		SNIAdapterConfig: make(map[string]string),
+		Policy:           new(Policy),
+		Hosts:            make(map[string]*HostConfig),
+		UnknownSNI:       UnknownSNIDefault,
+		Destinations:     make(map[string]string),
+		TicketOverlap:    1,
 	}
 }
 
 func LoadConfig(filePath string) (config *Config, err error) {
 	config = NewConfig()
 
-	config.Load(filePath)
+	err = config.Load(filePath)
+	if err != nil {
+		return
+	}
 
 	err = config.Verify()
 
@@ -41,12 +127,51 @@ func LoadConfig(filePath string) (config *Config, err error) {
 }
 
 func (config *Config) Load(filePath string) (err error) {
-	dict, err := ini.Load(filePath)
+	return config.LoadWith(filePath, nil)
+}
+
+// LoadWith loads filePath, which may be empty, with overrides replacing its
+// [cheesed] settings. Values may reference ${ENV_VAR} and ${file:/path}.
+// Unknown sections and keys, undefined references and values that do not
+// parse are errors naming the file and line they were read from.
+func (config *Config) LoadWith(filePath string, overrides map[string]string) (err error) {
+	dict := make(Dict)
+	origins := make(map[string]map[string]Origin)
+
+	if filePath != "" {
+		dict, origins, err = loadDict(filePath)
+		if err != nil {
+			return
+		}
+	}
 
+	if len(overrides) > 0 && dict["cheesed"] == nil {
+		dict["cheesed"] = make(map[string]string)
+		origins["cheesed"] = make(map[string]Origin)
+	}
+
+	for key, value := range overrides {
+		dict["cheesed"][strings.ToLower(key)] = value
+		origins["cheesed"][strings.ToLower(key)] = Origin{File: "flags or environment"}
+	}
+
+	interpolated, err := interpolate(dict, origins)
 	if err != nil {
 		return
 	}
 
+	if err = validate(dict, origins, interpolated); err != nil {
+		return
+	}
+
+	// validate catches what parsing would reject, but keep interpolated
+	// values out of anything it missed
+	defer func() {
+		if err != nil {
+			err = _error(interpolated.redactAll(err.Error()))
+		}
+	}()
+
 	s, found := dict.GetString("cheesed", "address")
 	if found {
 		config.Address = s
@@ -57,14 +182,167 @@ func (config *Config) Load(filePath string) (err error) {
 		config.Type = s
 	}
 
+	s, found = dict.GetString("cheesed", "listeners")
+	if found {
+		config.Listeners = nil
+
+		for _, s := range SplitList(s) {
+			listener, err := parseListener(s)
+			if err != nil {
+				return err
+			}
+
+			config.Listeners = append(config.Listeners, listener)
+		}
+	}
+
+	s, found = dict.GetString("cheesed", "log")
+	if found {
+		config.Log = s
+	}
+
+	s, found = dict.GetString("cheesed", "certificate")
+	if found {
+		config.Certificate = s
+	}
+
+	s, found = dict.GetString("cheesed", "key")
+	if found {
+		config.Key = s
+	}
+
+	s, found = dict.GetString("cheesed", "backend")
+	if found {
+		config.Backends = SplitList(s)
+	}
+
+	s, found = dict.GetString("cheesed", "unknownsni")
+	if found {
+		config.UnknownSNI = strings.ToLower(strings.TrimSpace(s))
+	}
+
+	s, found = dict.GetString("cheesed", "fallback")
+	if found {
+		config.Fallback = SplitList(s)
+	}
+
+	for ip, name := range dict["destinations"] {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+
+		config.Destinations[ip] = strings.ToLower(name)
+	}
+
+	s, found = dict.GetString("cheesed", "ticketkeys")
+	if found {
+		config.TicketKeys = s
+	}
+
+	s, found = dict.GetString("cheesed", "ticketrotation")
+	if found {
+		config.TicketRotation, err = time.ParseDuration(s)
+		if err != nil {
+			return
+		}
+	}
+
+	s, found = dict.GetString("cheesed", "ticketoverlap")
+	if found {
+		config.TicketOverlap, err = strconv.Atoi(s)
+		if err != nil {
+			return
+		}
+	}
+
+	s, found = dict.GetString("cheesed", "ocsp")
+	if found {
+		if config.OCSP, err = parseBool("ocsp", s); err != nil {
+			return
+		}
+	}
+
+	s, found = dict.GetString("cheesed", "ocspcache")
+	if found {
+		config.OCSPCache = s
+	}
+
+	s, found = dict.GetString("cheesed", "ocspresponder")
+	if found {
+		config.OCSPResponder = s
+	}
+
+	s, found = dict.GetString("cheesed", "ocspmuststaple")
+	if found {
+		if config.OCSPMustStaple, err = parseBool("ocspmuststaple", s); err != nil {
+			return
+		}
+	}
+
+	s, found = dict.GetString("cheesed", "signer")
+	if found {
+		config.Signer = s
+	}
+
+	s, found = dict.GetString("cheesed", "signertimeout")
+	if found {
+		config.SignerTimeout, err = time.ParseDuration(s)
+		if err != nil {
+			return
+		}
+	}
+
+	s, found = dict.GetString("cheesed", "passphrase")
+	if found {
+		config.Passphrase = s
+	}
+
+	s, found = dict.GetString("cheesed", "admin")
+	if found {
+		config.Admin = s
+	}
+
+	s, found = dict.GetString("cheesed", "control")
+	if found {
+		config.Control = s
+	}
+
+	s, found = dict.GetString("cheesed", "state")
+	if found {
+		config.State = s
+	}
+
 	s, found = dict.GetString("cheesed", "sniadapter")
 	if found {
 		config.SNIAdapterName = strings.ToLower(s)
 
-		sniConfig, found := dict[config.SNIAdapterName]
+		config.SNIAdapterConfig, err = adapterConfig(dict, config.SNIAdapterName, nil)
+		if err != nil {
+			return
+		}
+	}
+
+	config.Policy, err = ParsePolicy(dict["cheesed"])
+	if err != nil {
+		return
+	}
+
+	for section, settings := range dict {
+		if !strings.HasPrefix(section, "host:") {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(section, "host:")))
+
+		policy, err := ParsePolicy(settings)
+		if err != nil {
+			return _error("[" + section + "] " + err.Error())
+		}
 
-		if found {
-			config.SNIAdapterConfig = sniConfig
+		config.Hosts[name] = &HostConfig{
+			Name:     name,
+			Policy:   policy,
+			Backends: SplitList(settings["backend"]),
 		}
 	}
 
@@ -80,16 +358,202 @@ func (config *Config) Verify() (err error) {
 		return _error("Type cannot be empty")
 	}
 
-	switch config.Type {
+	if err = verifyAddress(config.Type, config.Address); err != nil {
+		return
+	}
+
+	if err = config.verifyUnknownSNI(config.UnknownSNI); err != nil {
+		return
+	}
+
+	for _, listener := range config.Listeners {
+		if err = verifyAddress(listener.Type, listener.Address); err != nil {
+			return
+		}
+
+		if listener.UnknownSNI == "" {
+			continue
+		}
+
+		if err = config.verifyUnknownSNI(listener.UnknownSNI); err != nil {
+			return
+		}
+	}
+
+	if config.TicketRotation < 0 {
+		return _error("TicketRotation cannot be negative")
+	}
+
+	if config.TicketOverlap < 0 {
+		return _error("TicketOverlap cannot be negative")
+	}
+
+	if config.SignerTimeout < 0 {
+		return _error("SignerTimeout cannot be negative")
+	}
+
+	if config.Admin != "" {
+		if err = verifyAdminAddress(config.Admin); err != nil {
+			return
+		}
+	}
+
+	if config.OCSPResponder != "" {
+		if _, err = url.Parse(config.OCSPResponder); err != nil {
+			return
+		}
+	}
+
+	if config.TicketKeys != "" {
+		if _, err = loadTicketKeyFile(config.TicketKeys); err != nil {
+			return
+		}
+	}
+
+	for ip := range config.Destinations {
+		if net.ParseIP(ip) == nil {
+			return _error("Invalid destination address: " + ip)
+		}
+	}
+
+	if err = config.Policy.Verify(); err != nil {
+		return
+	}
+
+	for name, host := range config.Hosts {
+		if err = host.Policy.Merge(config.Policy).Verify(); err != nil {
+			return _error("[host:" + name + "] " + err.Error())
+		}
+	}
+
+	return
+}
+
+// adapterSettings returns the adapter config with the server's signer, unless
+// the adapter names its own, so adapters resolve signer key references.
+func (config *Config) adapterSettings() map[string]string {
+	settings := make(map[string]string, len(config.SNIAdapterConfig)+2)
+	for key, value := range config.SNIAdapterConfig {
+		settings[key] = value
+	}
+
+	if _, ok := settings["signer"]; !ok && config.Signer != "" {
+		settings["signer"] = config.Signer
+
+		if config.SignerTimeout > 0 {
+			settings["signertimeout"] = config.SignerTimeout.String()
+		}
+	}
+
+	return settings
+}
+
+// adapterConfig returns the section for the named adapter. Sections of the
+// adapters it composes are embedded with an "<adapter>." key prefix.
+func adapterConfig(dict Dict, name string, parents []string) (map[string]string, error) {
+	for _, parent := range parents {
+		if parent == name {
+			return nil, _error("Adapter " + name + " includes itself")
+		}
+	}
+
+	config := make(map[string]string)
+	for key, value := range dict[name] {
+		config[key] = value
+	}
+
+	composed := append(SplitList(config["adapters"]), SplitList(config["adapter"])...)
+
+	for _, sub := range composed {
+		sub = strings.ToLower(sub)
+
+		subConfig, err := adapterConfig(dict, sub, append(parents, name))
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range subConfig {
+			config[sub+"."+key] = value
+		}
+	}
+
+	return config, nil
+}
+
+func verifyAddress(network, address string) (err error) {
+	switch network {
 	case "tcp", "tcp4", "tcp6":
-		_, err = net.ResolveTCPAddr(config.Type, config.Address)
+		_, err = net.ResolveTCPAddr(network, address)
 	case "unix", "unixpacket", "unixgram":
-		_, err = net.ResolveUnixAddr(config.Type, config.Address)
+		_, err = net.ResolveUnixAddr(network, address)
+	default:
+		err = _error("Unknown listener type: " + network)
 	}
 
 	return
 }
 
+func (config *Config) verifyUnknownSNI(policy string) error {
+	switch policy {
+	case UnknownSNIDefault, UnknownSNIReject, UnknownSNIDestination:
+	case UnknownSNIFallback:
+		if len(config.Fallback) == 0 {
+			return _error("UnknownSNI fallback requires a fallback backend")
+		}
+	default:
+		return _error("Unknown UnknownSNI policy: " + policy)
+	}
+
+	return nil
+}
+
+// parseListener parses "[type:]address [unknownsni=<policy>]". Addresses
+// without a type are unix sockets when they are paths and tcp otherwise. A
+// listener without an unknownsni option uses the global policy.
+func parseListener(s string) (*ListenerConfig, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, _error("Empty listener")
+	}
+
+	listener := &ListenerConfig{Type: "tcp", Address: fields[0]}
+
+	if strings.HasPrefix(listener.Address, "/") {
+		listener.Type = "unix"
+	}
+
+	for _, network := range []string{"tcp", "tcp4", "tcp6", "unix"} {
+		if strings.HasPrefix(fields[0], network+":") {
+			listener.Type, listener.Address = network, strings.TrimPrefix(fields[0], network+":")
+			break
+		}
+	}
+
+	for _, option := range fields[1:] {
+		key, value, _ := strings.Cut(option, "=")
+
+		switch strings.ToLower(key) {
+		case "unknownsni":
+			listener.UnknownSNI = strings.ToLower(value)
+		default:
+			return nil, _error("Unknown listener option " + option + " in " + s)
+		}
+	}
+
+	return listener, nil
+}
+
+func parseBool(key, s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "true", "yes":
+		return true, nil
+	case "off", "false", "no":
+		return false, nil
+	}
+
+	return false, _error("Invalid " + key + " value: " + s)
+}
+
 func _error(message string) (err error) {
 	return Error{message: message}
 }
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"crypto/tls"
 	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -49,6 +51,62 @@ func TestLoadIni(t *testing.T) {
 	socketConfig := loadTempConfig(socketIni, t)
 	assertEqual(socketConfig.Address, "/path/to/server.sock", "Address", t)
 	assertEqual(socketConfig.Type, "unix", "Type", t)
+	assertEqual(socketConfig.Certificate, "/path/to/server.p12", "Certificate", t)
+	assertEqual(socketConfig.Key, "signer:server", "Key", t)
+}
+
+func TestLoadWithOverrides(t *testing.T) {
+	path, err := tempIniFile(socketIni)
+	if err != nil {
+		t.Fatalf("Error writing ini file: %s", err.Error())
+	}
+
+	config := NewConfig()
+
+	err = config.LoadWith(path, map[string]string{"Address": "/path/to/other.sock", "minversion": "1.3"})
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err.Error())
+	}
+
+	assertEqual(config.Address, "/path/to/other.sock", "Address", t)
+	assertEqual(config.Type, "unix", "Type", t)
+
+	if config.Policy.MinVersion != tls.VersionTLS13 {
+		t.Fatal("Override did not reach the policy")
+	}
+
+	config = NewConfig()
+	if err = config.LoadWith("", map[string]string{"type": "tcp"}); err != nil {
+		t.Fatalf("Error loading config without a file: %s", err.Error())
+	}
+
+	assertEqual(config.Type, "tcp", "Type", t)
+}
+
+func TestParseListener(t *testing.T) {
+	listener, err := parseListener("tcp6:[::1]:8443 unknownsni=Reject")
+	if err != nil {
+		t.Fatalf("Error parsing listener: %s", err.Error())
+	}
+
+	assertEqual(listener.Type, "tcp6", "Type", t)
+	assertEqual(listener.Address, "[::1]:8443", "Address", t)
+	assertEqual(listener.UnknownSNI, UnknownSNIReject, "UnknownSNI", t)
+
+	listener, _ = parseListener("/run/cheesed.sock")
+	assertEqual(listener.Type, "unix", "Type", t)
+	assertEqual(listener.UnknownSNI, "", "UnknownSNI", t)
+
+	if _, err = parseListener("127.0.0.1:8443 backlog=10"); err == nil {
+		t.Fatal("parseListener did not catch an unknown option")
+	}
+
+	config := NewConfig()
+	config.Listeners = []*ListenerConfig{{Type: "tcp", Address: "127.0.0.1:8443", UnknownSNI: UnknownSNIFallback}}
+
+	if config.Verify() == nil {
+		t.Fatal("Verify did not catch a fallback listener without a fallback backend")
+	}
 }
 
 func TestSNIAdapterIni(t *testing.T) {
@@ -64,6 +122,81 @@ func TestSNIAdapterIni(t *testing.T) {
 	}
 }
 
+func TestChainAdapterIni(t *testing.T) {
+	config := loadTempConfig(chainIni, t)
+	assertEqual(config.SNIAdapterName, "chain", "SNIAdapter", t)
+	assertEqual(config.SNIAdapterConfig["inmemory.foo.example.com"], "/fake/path/to/*.pem", "InMemory", t)
+	assertEqual(config.SNIAdapterConfig["localca.hosts"], "*.test", "LocalCA", t)
+
+	path, err := tempIniFile(cyclicChainIni)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadConfig(path); err == nil {
+		t.Fatal("LoadConfig did not catch a chain that includes itself")
+	}
+}
+
+func TestPolicyIni(t *testing.T) {
+	config := loadTempConfig(policyIni, t)
+
+	if config.Policy.MinVersion != tls.VersionTLS12 {
+		t.Fatal("Error parsing global minversion")
+	}
+
+	host, ok := config.Hosts["secure.example.com"]
+	if !ok {
+		t.Fatal("Error parsing host section")
+	}
+
+	if host.Policy.MinVersion != tls.VersionTLS13 {
+		t.Fatal("Error parsing host minversion")
+	}
+
+	path, err := tempIniFile(invalidPolicyIni)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadConfig(path); err == nil {
+		t.Fatal("LoadConfig did not catch an invalid host policy")
+	}
+}
+
+func TestUnknownSNIIni(t *testing.T) {
+	config := loadTempConfig(unknownSNIIni, t)
+	assertEqual(config.UnknownSNI, "fallback", "UnknownSNI", t)
+	assertEqual(config.Fallback[1], "10.0.0.2:8080", "Fallback", t)
+	assertEqual(config.Destinations["192.0.2.10"], "foo.example.com", "Destinations", t)
+	assertEqual(config.Hosts["foo.example.com"].Backends[0], "/var/run/foo.sock", "Backend", t)
+
+	config = NewConfig()
+	config.UnknownSNI = "drop"
+
+	if config.Verify() == nil {
+		t.Fatal("Verify did not catch an unknown UnknownSNI policy")
+	}
+
+	config = NewConfig()
+	config.UnknownSNI = UnknownSNIFallback
+
+	if config.Verify() == nil {
+		t.Fatal("Verify did not catch a fallback policy without a backend")
+	}
+}
+
+func TestOCSPIni(t *testing.T) {
+	config := loadTempConfig(ocspIni, t)
+
+	if !config.OCSP || !config.OCSPMustStaple {
+		t.Fatal("Error parsing OCSP flags")
+	}
+
+	assertEqual(config.OCSPCache, "/var/cache/cheesed/ocsp", "OCSPCache", t)
+	assertEqual(config.OCSPResponder, "http://127.0.0.1:8888", "OCSPResponder", t)
+}
+
 func assertEqual(actual, expected, description string, t *testing.T) {
 	if actual != expected {
 		t.Fatalf("Ini parse failed on %s: %s != %s", description, actual, expected)
@@ -116,6 +249,8 @@ Type    = tcp4;
 
 address = /path/to/server.sock;
 TYPE    = unix;
+Certificate = /path/to/server.p12;
+Key     = signer:server;
 `
 	sniIni = `#
 # SNI adapter ini file
@@ -127,4 +262,229 @@ SNIAdapter = InMemory
 [InMemory]
 foo.example.com = /fake/path/to/*.pem;
 `
+	policyIni = `#
+# TLS policy ini file
+
+[Cheesed]
+
+MinVersion = 1.2
+Curves     = X25519MLKEM768, X25519, P-256
+
+[host:Secure.Example.com]
+MinVersion     = 1.3
+SessionTickets = off
+`
+	invalidPolicyIni = `#
+# invalid TLS policy ini file
+
+[cheesed]
+
+MaxVersion = 1.2
+
+[host:legacy.example.com]
+MinVersion = 1.3
+`
+	unknownSNIIni = `#
+# unknown SNI ini file
+
+[cheesed]
+
+UnknownSNI = Fallback
+Fallback   = 10.0.0.1:8080, 10.0.0.2:8080
+
+[destinations]
+192.0.2.10 = Foo.example.com
+
+[host:foo.example.com]
+backend = /var/run/foo.sock
+`
+	ocspIni = `#
+# OCSP stapling ini file
+
+[cheesed]
+
+OCSP           = on
+OCSPCache      = /var/cache/cheesed/ocsp
+OCSPResponder  = http://127.0.0.1:8888
+OCSPMustStaple = yes
+`
+	chainIni = `#
+# chain adapter ini file
+
+[cheesed]
+
+SNIAdapter = Chain
+
+[chain]
+adapters = InMemory, LocalCA
+
+[InMemory]
+foo.example.com = /fake/path/to/*.pem
+
+[localca]
+hosts = *.test
+ca    = /fake/path/to/ca.pem
+cakey = /fake/path/to/ca.key
+`
+	cyclicChainIni = `#
+# cyclic chain adapter ini file
+
+[cheesed]
+
+SNIAdapter = chain
+
+[chain]
+adapters = inmemory, chain
+`
+)
+
+func TestStructuredConfig(t *testing.T) {
+	for ext, body := range map[string]string{".yaml": yamlConfig, ".toml": tomlConfig, ".json": jsonConfig} {
+		file, err := ioutil.TempFile("", "cheesed*"+ext)
+		if err != nil {
+			t.Fatalf("Error creating config file: %s", err.Error())
+		}
+
+		file.WriteString(body)
+		file.Close()
+
+		config := NewConfig()
+		if err = config.Load(file.Name()); err != nil {
+			t.Fatalf("Error loading %s config: %s", ext, err.Error())
+		}
+
+		if err = config.Verify(); err != nil {
+			t.Fatalf("Invalid %s config: %s", ext, err.Error())
+		}
+
+		assertEqual(config.Address, "127.0.0.1:8443", ext+" Address", t)
+		assertEqual(strings.Join(config.Backends, " "), "10.0.0.1:80 10.0.0.2:80", ext+" Backends", t)
+		assertEqual(config.SNIAdapterConfig["foo.example.com"], "/etc/foo.crt,/etc/foo.key", ext+" adapter", t)
+		assertEqual(config.Destinations["10.0.0.5"], "foo.example.com", ext+" destinations", t)
+
+		if len(config.Listeners) != 2 || config.Listeners[0].Type != "tcp6" || config.Listeners[0].UnknownSNI != UnknownSNIReject || config.Listeners[1].Type != "unix" {
+			t.Fatalf("Unexpected %s listeners: %+v", ext, config.Listeners)
+		}
+
+		if config.Policy.MinVersion != tls.VersionTLS10 || len(config.Policy.CurvePreferences) != 2 {
+			t.Fatalf("Unexpected %s policy: %+v", ext, config.Policy)
+		}
+
+		host := config.Hosts["foo.example.com"]
+		if host == nil || host.Policy.MinVersion != tls.VersionTLS13 || host.Backends[0] != "unix:/run/foo.sock" {
+			t.Fatalf("Unexpected %s host: %+v", ext, host)
+		}
+
+		if !config.OCSP {
+			t.Fatalf("%s boolean was not parsed", ext)
+		}
+	}
+}
+
+func TestStructuredConfigOrigins(t *testing.T) {
+	for ext, body := range map[string]string{".yaml": yamlConfig, ".toml": tomlConfig, ".json": jsonConfig} {
+		file, err := ioutil.TempFile("", "cheesed*"+ext)
+		if err != nil {
+			t.Fatalf("Error creating config file: %s", err.Error())
+		}
+
+		file.WriteString(body)
+		file.Close()
+
+		_, origins, err := loadDict(file.Name())
+		if err != nil {
+			t.Fatalf("Error loading %s config: %s", ext, err.Error())
+		}
+
+		for _, setting := range [][3]string{
+			{"cheesed", "address", "address"},
+			{"cheesed", "minversion", "minversion"},
+			{"host:foo.example.com", "backend", "unix:/run/foo.sock"},
+		} {
+			line := 0
+			for i, text := range strings.Split(body, "\n") {
+				if strings.Contains(text, setting[2]) {
+					line = i + 1
+					break
+				}
+			}
+
+			origin := origins[setting[0]][setting[1]]
+			if origin.File != file.Name() || origin.Line != line {
+				t.Errorf("%s [%s] %s: expected line %d, got %s", ext, setting[0], setting[1], line, origin)
+			}
+		}
+	}
+}
+
+var (
+	yamlConfig = `
+cheesed:
+  address: 127.0.0.1:8443
+  type: tcp
+  backend: [10.0.0.1:80, 10.0.0.2:80]
+  ocsp: true
+  sniadapter: inmemory
+  listeners:
+    - {type: tcp6, address: "[::1]:8443", unknownsni: reject}
+    - /tmp/cheesed.sock
+  policy:
+    minversion: 1.0
+    curves: [x25519, p256]
+hosts:
+  foo.example.com:
+    backend: [unix:/run/foo.sock]
+    policy: {minversion: 1.3}
+destinations:
+  10.0.0.5: foo.example.com
+inmemory:
+  foo.example.com: [/etc/foo.crt, /etc/foo.key]
+`
+	tomlConfig = `
+[cheesed]
+address = "127.0.0.1:8443"
+type = "tcp"
+backend = ["10.0.0.1:80", "10.0.0.2:80"]
+ocsp = true
+sniadapter = "inmemory"
+
+[[cheesed.listeners]]
+type = "tcp6"
+address = "[::1]:8443"
+unknownsni = "reject"
+
+[[cheesed.listeners]]
+type = "unix"
+address = "/tmp/cheesed.sock"
+
+[cheesed.policy]
+minversion = 1.0
+curves = ["x25519", "p256"]
+
+[hosts."foo.example.com"]
+backend = ["unix:/run/foo.sock"]
+policy = {minversion = 1.3}
+
+[destinations]
+"10.0.0.5" = "foo.example.com"
+
+[inmemory]
+"foo.example.com" = ["/etc/foo.crt", "/etc/foo.key"]
+`
+	jsonConfig = `{
+  "cheesed": {
+    "address": "127.0.0.1:8443",
+    "type": "tcp",
+    "backend": ["10.0.0.1:80", "10.0.0.2:80"],
+    "ocsp": true,
+    "sniadapter": "inmemory",
+    "listeners": [{"type": "tcp6", "address": "[::1]:8443", "unknownsni": "reject"}, "/tmp/cheesed.sock"],
+    "policy": {"minversion": 1.0, "curves": ["x25519", "p256"]}
+  },
+  "hosts": {
+    "foo.example.com": {"backend": ["unix:/run/foo.sock"], "policy": {"minversion": 1.3}}
+  },
+  "destinations": {"10.0.0.5": "foo.example.com"},
+  "inmemory": {"foo.example.com": ["/etc/foo.crt", "/etc/foo.key"]}
+}`
 )
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/benburkert/cheeseman/signer"
+	"github.com/benburkert/cheeseman/sni"
+)
+
+// The control socket serves the operational subset of the admin API to the
+// reload, status and shutdown commands:
+//
+//	POST /reload              reload the adapter and ticket keys
+//	GET  /status              listener, adapter and connection status
+//	GET  /connections         proxied connections
+//	POST /shutdown?grace=30s  stop accepting and drain connections
+type Status struct {
+	Listeners   []ListenerStatus `json:"listeners"`
+	Adapter     AdapterStatus    `json:"adapter"`
+	Connections int              `json:"connections"`
+	Started     time.Time        `json:"started"`
+	Signer      *signer.Stats    `json:"signer,omitempty"`
+}
+
+type ListenerStatus struct {
+	Network    string `json:"network"`
+	Address    string `json:"address"`
+	UnknownSNI string `json:"unknownsni"`
+}
+
+type AdapterStatus struct {
+	Name         string          `json:"name"`
+	Reloadable   bool            `json:"reloadable"`
+	Mutable      bool            `json:"mutable"`
+	Certificates int             `json:"certificates"`
+	Protocols    []string        `json:"protocols,omitempty"`
+	Cache        *sni.CacheStats `json:"cache,omitempty"`
+}
+
+var (
+	defaultGrace = 30 * time.Second
+)
+
+var (
+	umaskLock sync.Mutex
+)
+
+// listenUnix listens on a unix socket only the server's user can connect to.
+// The socket is created that way rather than changed after it is bound, and
+// a socket left behind by a crash is removed when nothing answers on it.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+
+	return net.Listen("unix", path)
+}
+
+func (srv *Server) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reload", srv.adminReload)
+	mux.HandleFunc("/status", srv.adminStatus)
+	mux.HandleFunc("/connections", srv.adminConnections)
+	mux.HandleFunc("/shutdown", srv.adminShutdown)
+
+	return mux
+}
+
+func (srv *Server) Status() *Status {
+	status := &Status{
+		Adapter: AdapterStatus{Name: srv.adapterName},
+		Started: srv.started,
+	}
+
+	for _, listener := range append([]*Listener{srv.listener}, srv.extraListeners...) {
+		addr := listener.inner.Addr()
+
+		policy := listener.unknownSNI
+		if policy == "" {
+			policy = srv.unknownSNI
+		}
+
+		status.Listeners = append(status.Listeners, ListenerStatus{Network: addr.Network(), Address: addr.String(), UnknownSNI: policy})
+	}
+
+	srv.routesLock.Lock()
+	status.Connections = len(srv.routes)
+	srv.routesLock.Unlock()
+
+	_, status.Adapter.Reloadable = srv.sniAdapter.(sni.Reloader)
+	_, status.Adapter.Mutable = srv.sniAdapter.(sni.Mutator)
+
+	if enumerator, ok := srv.sniAdapter.(sni.Enumerator); ok {
+		status.Adapter.Certificates = len(enumerator.Certificates())
+	}
+
+	status.Adapter.Protocols = append([]string(nil), srv.sniProtos...)
+	sort.Strings(status.Adapter.Protocols)
+
+	if stats, ok := sni.Stats(srv.sniAdapter); ok {
+		status.Adapter.Cache = &stats
+	}
+
+	if srv.signer != nil {
+		stats := srv.signer.Stats()
+		status.Signer = &stats
+	}
+
+	return status
+}
+
+func (srv *Server) adminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	adminJSON(w, http.StatusOK, srv.Status())
+}
+
+func (srv *Server) adminShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		adminError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	grace := defaultGrace
+	if s := r.URL.Query().Get("grace"); s != "" {
+		var err error
+
+		if grace, err = time.ParseDuration(s); err != nil {
+			adminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	// let the response go out before the socket closes
+	go srv.Shutdown(grace)
+}
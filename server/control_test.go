@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControlSocket(t *testing.T) {
+	config := testConfig(t)
+	config.Control = filepath.Join(filepath.Dir(config.Address), "control.sock")
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+	config.Backends = []string{echoBackend(t)}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+
+	var runErr error
+
+	stopped := make(chan struct{})
+	go func() {
+		runErr = srv.Run()
+		close(stopped)
+	}()
+
+	cli := insecureClient(config, "foo.example.org", t)
+	defer cli.Close()
+
+	if _, err := cli.Write([]byte("ping")); err != nil {
+		t.Fatalf("Error writing to backend: %s", err.Error())
+	}
+
+	var status Status
+	if err := AdminCall(config.Control, "GET", "/status", &status); err != nil {
+		t.Fatalf("Error fetching status: %s", err.Error())
+	}
+
+	if status.Adapter.Name != "inmemory" || status.Adapter.Certificates != 1 || status.Connections != 1 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+
+	if err := AdminCall(config.Control, "POST", "/reload", nil); err != nil {
+		t.Fatalf("Error reloading: %s", err.Error())
+	}
+
+	if err := AdminCall(config.Control, "PUT", "/hosts/foo.example.org", nil); err == nil {
+		t.Fatal("Control socket served a certificate mutation")
+	}
+
+	if err := AdminCall(config.Control, "POST", "/shutdown?grace=5s", nil); err != nil {
+		t.Fatalf("Error shutting down: %s", err.Error())
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown did not wait for the open connection")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cli.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server did not stop after draining")
+	}
+
+	if runErr != nil {
+		t.Fatalf("Run failed after a shutdown: %s", runErr.Error())
+	}
+
+	if _, err := net.Dial("unix", config.Address); err == nil {
+		t.Fatal("Server accepted a connection after shutdown")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	// a crashed server leaves its socket behind
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Error creating socket: %s", err.Error())
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("Stale socket was not replaced: %s", err.Error())
+	}
+	defer listener.Close()
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected socket permissions: %v", info.Mode())
+	}
+
+	if _, err = listenUnix(path); err == nil {
+		t.Fatal("A socket in use was replaced")
+	}
+
+	if conn, err := net.Dial("unix", path); err != nil {
+		t.Fatalf("Socket in use was removed: %s", err.Error())
+	} else {
+		conn.Close()
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dict holds config settings by lower case section and key.
+type Dict map[string]map[string]string
+
+// Origin is where a section or setting was read.
+type Origin struct {
+	File string
+	Line int
+}
+
+type iniParser struct {
+	dict    Dict
+	origins map[string]map[string]Origin
+	stack   []string
+}
+
+func (dict Dict) GetString(section, key string) (string, bool) {
+	value, ok := dict[strings.ToLower(section)][strings.ToLower(key)]
+	return value, ok
+}
+
+func (origin Origin) String() string {
+	if origin.Line == 0 {
+		return origin.File
+	}
+
+	return origin.File + ":" + strconv.Itoa(origin.Line)
+}
+
+// loadDict reads filePath and everything it includes. A setting read twice
+// with different values, or a [host:*] section in more than one file, is an
+// error naming both places.
+func loadDict(filePath string) (Dict, map[string]map[string]Origin, error) {
+	parser := &iniParser{
+		dict:    make(Dict),
+		origins: make(map[string]map[string]Origin),
+	}
+
+	if err := parser.load(filePath); err != nil {
+		return nil, nil, err
+	}
+
+	return parser.dict, parser.origins, nil
+}
+
+func (parser *iniParser) load(filePath string) error {
+	path, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, parent := range parser.stack {
+		if parent == path {
+			return _error(filePath + " includes itself")
+		}
+	}
+
+	parser.stack = append(parser.stack, path)
+	defer func() { parser.stack = parser.stack[:len(parser.stack)-1] }()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml", ".yaml", ".yml", ".json":
+		return parser.loadStructured(path)
+	}
+
+	return parser.loadINI(path)
+}
+
+func (parser *iniParser) loadINI(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		at := Origin{File: path, Line: line}
+
+		text := stripComment(strings.TrimSpace(scanner.Text()))
+
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			section = strings.ToLower(strings.TrimSpace(text[1 : len(text)-1]))
+
+			if err = parser.section(section, at); err != nil {
+				return err
+			}
+		case isInclude(text):
+			if err = parser.include(strings.TrimSpace(text[len("include"):]), at); err != nil {
+				return err
+			}
+		default:
+			i := strings.Index(text, "=")
+			if i < 0 {
+				return _error(at.String() + ": expected key = value")
+			}
+
+			if err = parser.set(section, text[:i], text[i+1:], at); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// stripComment drops a line starting with # or ;, a # or ; comment that
+// follows whitespace and a trailing ;, leaving values such as
+// sh -c 'a; b' or a URL fragment alone.
+func stripComment(text string) string {
+	if strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+		return ""
+	}
+
+	for i := 1; i < len(text); i++ {
+		if (text[i] == '#' || text[i] == ';') && (text[i-1] == ' ' || text[i-1] == '\t') {
+			text = strings.TrimSpace(text[:i])
+			break
+		}
+	}
+
+	return strings.TrimSpace(strings.TrimSuffix(text, ";"))
+}
+
+func (parser *iniParser) loadStructured(path string) error {
+	dict, lines, includes, err := loadStructured(path)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(dict))
+	for name := range dict {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err = parser.section(name, Origin{File: path, Line: lines[name][""]}); err != nil {
+			return err
+		}
+
+		for key, value := range dict[name] {
+			if err = parser.set(name, key, value, Origin{File: path, Line: lines[name][key]}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, pattern := range includes {
+		if err = parser.include(pattern, Origin{File: path}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// include loads the files matching pattern, relative to the including file,
+// in lexical order. A pattern without wildcards must match a file.
+func (parser *iniParser) include(pattern string, at Origin) error {
+	if pattern == "" {
+		return _error(at.String() + ": include requires a path")
+	}
+
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(at.File), pattern)
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return _error(at.String() + ": " + err.Error())
+	}
+
+	if len(paths) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		return _error(at.String() + ": included file " + pattern + " does not exist")
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err = parser.load(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (parser *iniParser) section(name string, at Origin) error {
+	if parser.dict[name] == nil {
+		parser.dict[name] = make(map[string]string)
+		parser.origins[name] = map[string]Origin{"": at}
+
+		return nil
+	}
+
+	first := parser.origins[name][""]
+	if strings.HasPrefix(name, "host:") && first.File != at.File {
+		return _error(at.String() + ": duplicate [" + name + "], first defined at " + first.String())
+	}
+
+	return nil
+}
+
+func (parser *iniParser) set(section, key, value string, at Origin) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+
+	if parser.dict[section] == nil {
+		if err := parser.section(section, at); err != nil {
+			return err
+		}
+	}
+
+	if previous, ok := parser.dict[section][key]; ok && previous != value {
+		return _error(at.String() + ": [" + section + "] " + key + " conflicts with the value set at " + parser.origins[section][key].String())
+	}
+
+	parser.dict[section][key] = value
+	parser.origins[section][key] = at
+
+	return nil
+}
+
+func isInclude(text string) bool {
+	return len(text) > len("include") &&
+		strings.EqualFold(text[:len("include")], "include") &&
+		(text[len("include")] == ' ' || text[len("include")] == '\t') &&
+		!strings.Contains(text, "=")
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIniIncludes(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfigFile(dir, "cheesed.ini", `
+[cheesed]
+address = 127.0.0.1:8443
+sniadapter = inmemory
+
+include conf.d/*.ini
+include shared.yaml
+`, t)
+
+	writeConfigFile(dir, "conf.d/10-foo.ini", `
+[host:foo.example.com]
+backend = 10.0.0.1:80
+
+[inmemory]
+foo.example.com = /etc/foo.crt,/etc/foo.key
+`, t)
+
+	writeConfigFile(dir, "conf.d/20-bar.ini", `
+[cheesed]
+address = 127.0.0.1:8443 ; repeating the same value is fine
+
+[inmemory]
+bar.example.com = /etc/bar.crt,/etc/bar.key
+`, t)
+
+	writeConfigFile(dir, "shared.yaml", `
+destinations:
+  10.0.0.5: foo.example.com
+`, t)
+
+	config := NewConfig()
+	if err := config.Load(filepath.Join(dir, "cheesed.ini")); err != nil {
+		t.Fatalf("Error loading config: %s", err.Error())
+	}
+
+	assertEqual(config.Address, "127.0.0.1:8443", "Address", t)
+	assertEqual(config.Hosts["foo.example.com"].Backends[0], "10.0.0.1:80", "host backend", t)
+	assertEqual(config.SNIAdapterConfig["bar.example.com"], "/etc/bar.crt,/etc/bar.key", "adapter", t)
+	assertEqual(config.Destinations["10.0.0.5"], "foo.example.com", "destinations", t)
+}
+
+func TestIniConflicts(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfigFile(dir, "conf.d/10-foo.ini", "[host:foo.example.com]\nbackend = 10.0.0.1:80\n", t)
+	writeConfigFile(dir, "conf.d/20-foo.ini", "\n[host:foo.example.com]\nbackend = 10.0.0.1:80\n", t)
+	writeConfigFile(dir, "duplicate.ini", "include conf.d/*.ini\n", t)
+
+	assertLoadError(filepath.Join(dir, "duplicate.ini"), "20-foo.ini:2: duplicate [host:foo.example.com], first defined at "+filepath.Join(dir, "conf.d/10-foo.ini")+":1", t)
+
+	writeConfigFile(dir, "conflict.ini", "[cheesed]\naddress = 127.0.0.1:1\ninclude other.ini\n", t)
+	writeConfigFile(dir, "other.ini", "[cheesed]\n\naddress = 127.0.0.1:2\n", t)
+
+	assertLoadError(filepath.Join(dir, "conflict.ini"), "other.ini:3: [cheesed] address conflicts with the value set at "+filepath.Join(dir, "conflict.ini")+":2", t)
+
+	writeConfigFile(dir, "loop.ini", "include loop.ini\n", t)
+	assertLoadError(filepath.Join(dir, "loop.ini"), "includes itself", t)
+
+	writeConfigFile(dir, "missing.ini", "include nowhere.ini\n", t)
+	assertLoadError(filepath.Join(dir, "missing.ini"), "missing.ini:1: included file", t)
+}
+
+func TestIniComments(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfigFile(dir, "cheesed.ini", `
+; a comment
+# another comment
+[exec]
+command = sh -c 'echo a; exec helper'
+url = https://example.com/#fragment
+timeout = 5s # an inline comment
+refresh = 1m ; another inline comment
+processes = 2;
+`, t)
+
+	dict, _, err := loadDict(filepath.Join(dir, "cheesed.ini"))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err.Error())
+	}
+
+	assertEqual(dict["exec"]["command"], "sh -c 'echo a; exec helper'", "command", t)
+	assertEqual(dict["exec"]["url"], "https://example.com/#fragment", "url", t)
+	assertEqual(dict["exec"]["timeout"], "5s", "timeout", t)
+	assertEqual(dict["exec"]["refresh"], "1m", "refresh", t)
+	assertEqual(dict["exec"]["processes"], "2", "processes", t)
+}
+
+func writeConfigFile(dir, name, body string, t *testing.T) {
+	path := filepath.Join(dir, name)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("Error creating config dir: %s", err.Error())
+	}
+
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatalf("Error writing config file: %s", err.Error())
+	}
+}
+
+func assertLoadError(path, expected string, t *testing.T) {
+	err := NewConfig().Load(path)
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("Expected an error containing %q, got: %v", expected, err)
+	}
+}
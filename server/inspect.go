@@ -0,0 +1,240 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/benburkert/cheeseman/sni"
+)
+
+// Selection is what the server would do for a client hello.
+type Selection struct {
+	ServerName string
+	Known      bool
+	Rejected   bool
+	Chain      []*x509.Certificate
+	Config     *tls.Config
+	Backends   []string
+	Protocol   string
+	Supported  error
+}
+
+// inspectConn stands in for a client connection so destination routing can
+// see a local address.
+type inspectConn struct {
+	net.Conn
+	local net.Addr
+}
+
+var (
+	signatureSchemes = map[string]tls.SignatureScheme{
+		"rsa_pkcs1_sha256":       tls.PKCS1WithSHA256,
+		"rsa_pkcs1_sha384":       tls.PKCS1WithSHA384,
+		"rsa_pkcs1_sha512":       tls.PKCS1WithSHA512,
+		"rsa_pss_rsae_sha256":    tls.PSSWithSHA256,
+		"rsa_pss_rsae_sha384":    tls.PSSWithSHA384,
+		"rsa_pss_rsae_sha512":    tls.PSSWithSHA512,
+		"ecdsa_secp256r1_sha256": tls.ECDSAWithP256AndSHA256,
+		"ecdsa_secp384r1_sha384": tls.ECDSAWithP384AndSHA384,
+		"ecdsa_secp521r1_sha512": tls.ECDSAWithP521AndSHA512,
+		"ed25519":                tls.Ed25519,
+		"rsa_pkcs1_sha1":         tls.PKCS1WithSHA1,
+		"ecdsa_sha1":             tls.ECDSAWithSHA1,
+	}
+)
+
+// Inspect runs the certificate, policy and backend selection for hello
+// without listening or staple fetches. Certificates are only looked up, never
+// issued, see sni.Peek.
+func Inspect(config *Config, hello *tls.ClientHelloInfo) (*Selection, error) {
+	srv := new(Server)
+	srv.log = log.New(ioutil.Discard, "", 0)
+	srv.peek = true
+
+	if err := srv.configure(config); err != nil {
+		return nil, err
+	}
+
+	if closer, ok := srv.sniAdapter.(sni.Closer); ok {
+		defer closer.Close()
+	}
+
+	srv.stapler = nil
+
+	if hello.SupportedVersions == nil {
+		hello.SupportedVersions = []uint16{tls.VersionTLS13, tls.VersionTLS12}
+	}
+
+	if hello.SupportedCurves == nil {
+		hello.SupportedCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+		hello.SupportedPoints = []uint8{0}
+	}
+
+	if hello.SignatureSchemes == nil {
+		for _, scheme := range signatureSchemes {
+			hello.SignatureSchemes = append(hello.SignatureSchemes, scheme)
+		}
+	}
+
+	tlsConfig, rt, err := srv.selectConfig(hello)
+	if err != nil {
+		return nil, err
+	}
+
+	selection := &Selection{ServerName: hello.ServerName, Config: tlsConfig, Rejected: rt == nil}
+	if selection.Rejected {
+		return selection, nil
+	}
+
+	selection.ServerName = rt.serverName
+	selection.Known = rt.known
+	selection.Backends = rt.backends
+
+	if len(tlsConfig.NextProtos) > 0 {
+		selection.Protocol = tlsConfig.NextProtos[0]
+	}
+
+	cert := &tlsConfig.Certificates[0]
+	for _, der := range cert.Certificate {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+
+		selection.Chain = append(selection.Chain, parsed)
+	}
+
+	selection.Supported = hello.SupportsCertificate(cert)
+
+	return selection, nil
+}
+
+// InspectHello builds a client hello for Inspect from command line values.
+func InspectHello(serverName, protos, schemes, local string) (*tls.ClientHelloInfo, error) {
+	hello := &tls.ClientHelloInfo{
+		ServerName:      serverName,
+		SupportedProtos: SplitList(protos),
+	}
+
+	for _, name := range SplitList(schemes) {
+		scheme, ok := signatureSchemes[strings.ToLower(name)]
+		if !ok {
+			return nil, _error("Unknown signature scheme: " + name)
+		}
+
+		hello.SignatureSchemes = append(hello.SignatureSchemes, scheme)
+	}
+
+	if local != "" {
+		ip := net.ParseIP(local)
+		if ip == nil {
+			return nil, _error("Invalid local address: " + local)
+		}
+
+		hello.Conn = inspectConn{local: &net.TCPAddr{IP: ip, Port: 443}}
+	}
+
+	return hello, nil
+}
+
+func (conn inspectConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+func (selection *Selection) WriteTo(w io.Writer) (int64, error) {
+	var lines []string
+
+	if selection.Rejected {
+		lines = append(lines, "servername:  "+selection.ServerName, "result:      rejected (unrecognized_name)")
+		return writeLines(w, lines)
+	}
+
+	result := "default certificate"
+	if selection.Known {
+		result = "adapter certificate"
+	}
+
+	lines = append(lines,
+		"servername:  "+selection.ServerName,
+		"result:      "+result,
+	)
+
+	for i, cert := range selection.Chain {
+		lines = append(lines, fmt.Sprintf("chain[%d]:    %s (issuer %s, expires %s)", i, cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format(time.RFC3339)))
+	}
+
+	if len(selection.Chain) > 0 && len(selection.Chain[0].DNSNames) > 0 {
+		lines = append(lines, "names:       "+strings.Join(selection.Chain[0].DNSNames, ", "))
+	}
+
+	if selection.Supported != nil {
+		lines = append(lines, "client:      unsupported ("+selection.Supported.Error()+")")
+	} else {
+		lines = append(lines, "client:      supported")
+	}
+
+	lines = append(lines, "versions:    "+tls.VersionName(minVersion(selection.Config))+" - "+tls.VersionName(maxVersion(selection.Config)))
+
+	if len(selection.Config.CipherSuites) > 0 {
+		var names []string
+		for _, id := range selection.Config.CipherSuites {
+			names = append(names, tls.CipherSuiteName(id))
+		}
+
+		lines = append(lines, "ciphers:     "+strings.Join(names, ", "))
+	}
+
+	if len(selection.Config.CurvePreferences) > 0 {
+		var names []string
+		for _, id := range selection.Config.CurvePreferences {
+			names = append(names, id.String())
+		}
+
+		lines = append(lines, "curves:      "+strings.Join(names, ", "))
+	}
+
+	tickets := "on"
+	if selection.Config.SessionTicketsDisabled {
+		tickets = "off"
+	}
+	lines = append(lines, "tickets:     "+tickets)
+
+	switch {
+	case selection.Protocol != "":
+		lines = append(lines, "protocol:    "+selection.Protocol+" (answered by the adapter, not proxied)")
+	case len(selection.Backends) > 0:
+		lines = append(lines, "backends:    "+strings.Join(selection.Backends, ", "))
+	default:
+		lines = append(lines, "backends:    none (connection is closed after the handshake)")
+	}
+
+	return writeLines(w, lines)
+}
+
+func minVersion(config *tls.Config) uint16 {
+	if config.MinVersion != 0 {
+		return config.MinVersion
+	}
+
+	return tls.VersionTLS12
+}
+
+func maxVersion(config *tls.Config) uint16 {
+	if config.MaxVersion != 0 {
+		return config.MaxVersion
+	}
+
+	return tls.VersionTLS13
+}
+
+func writeLines(w io.Writer, lines []string) (int64, error) {
+	n, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return int64(n), err
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestInspect(t *testing.T) {
+	config := testConfig(t)
+	config.SNIAdapterConfig["foo.example.org"] = testHostPair("foo.example.org", t)
+	config.Backends = []string{"127.0.0.1:8080"}
+	config.Hosts["foo.example.org"] = &HostConfig{
+		Name:     "foo.example.org",
+		Policy:   &Policy{MinVersion: 0x0304},
+		Backends: []string{"unix:/run/foo.sock"},
+	}
+
+	hello, err := InspectHello("FOO.example.org", "h2", "rsa_pss_rsae_sha256", "")
+	if err != nil {
+		t.Fatalf("Error building hello: %s", err.Error())
+	}
+
+	selection, err := Inspect(config, hello)
+	if err != nil {
+		t.Fatalf("Error inspecting: %s", err.Error())
+	}
+
+	if !selection.Known || selection.Chain[0].Subject.CommonName != "foo.example.org" {
+		t.Fatalf("Unexpected selection: %+v", selection)
+	}
+
+	if selection.Supported != nil {
+		t.Fatalf("Certificate should suit the client: %s", selection.Supported.Error())
+	}
+
+	var buf bytes.Buffer
+	selection.WriteTo(&buf)
+
+	for _, expected := range []string{"adapter certificate", "TLS 1.3 - TLS 1.3", "unix:/run/foo.sock"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Report is missing %q:\n%s", expected, buf.String())
+		}
+	}
+
+	hello, _ = InspectHello("bar.example.org", "", "ecdsa_secp256r1_sha256", "")
+	config.UnknownSNI = UnknownSNIReject
+
+	if selection, err = Inspect(config, hello); err != nil || !selection.Rejected {
+		t.Fatalf("Unknown name was not rejected: %v", err)
+	}
+
+	if _, err = InspectHello("foo.example.org", "", "rot13", ""); err == nil {
+		t.Fatal("Expected an error for an unknown signature scheme")
+	}
+}
+
+func TestInspectDoesNotIssue(t *testing.T) {
+	ca, key, err := pki.GenerateCA("ca.test", pki.KeyTypeECDSA, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+	cache := t.TempDir()
+
+	config := testConfig(t)
+	config.SNIAdapterName = "localca"
+	config.SNIAdapterConfig = map[string]string{
+		"ca":    tempFile(string(pki.EncodeCertificate(ca)), t),
+		"cakey": tempFile(string(keyPEM), t),
+		"hosts": "*.test",
+		"cache": cache,
+	}
+
+	hello, _ := InspectHello("app.test", "", "", "")
+
+	selection, err := Inspect(config, hello)
+	if err != nil {
+		t.Fatalf("Error inspecting: %s", err.Error())
+	}
+
+	if selection.Known {
+		t.Fatal("Inspect reported a certificate that has not been issued")
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(cache, "*")); len(files) != 0 {
+		t.Fatalf("Inspect issued certificates: %v", files)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// interpolate replaces ${NAME} with the environment variable NAME and
+// ${file:path} with the contents of path, less a trailing newline, in every
+// value of dict. Relative paths are resolved against the directory of the
+// file the value was read from, and $${ is a literal ${. The returned secrets
+// let later errors show the references instead of what they resolved to.
+func interpolate(dict Dict, origins map[string]map[string]Origin) (secrets, error) {
+	var problems []problem
+
+	found := make(secrets)
+
+	for section, settings := range dict {
+		for key, value := range settings {
+			at := origins[section][key]
+
+			expanded, resolved, err := expand(value, at)
+			if err != nil {
+				problems = append(problems, problem{at: at, message: "[" + section + "] " + key + ": " + err.Error()})
+				continue
+			}
+
+			if len(resolved) > 0 {
+				if found[section] == nil {
+					found[section] = make(map[string][]secret)
+				}
+
+				found[section][key] = append([]secret{{value: expanded, ref: value}}, resolved...)
+			}
+
+			settings[key] = expanded
+		}
+	}
+
+	return found, problemError(problems)
+}
+
+// secrets holds, for each interpolated setting, its value and the values its
+// references resolved to, each with the text it was written as.
+type secrets map[string]map[string][]secret
+
+type secret struct {
+	value string
+	ref   string
+}
+
+// redact replaces what the references of section's key resolved to in
+// message with the references themselves.
+func (found secrets) redact(section, key, message string) string {
+	for _, secret := range found[section][key] {
+		if secret.value != "" {
+			message = strings.Replace(message, secret.value, secret.ref, -1)
+		}
+	}
+
+	return message
+}
+
+// redactAll is redact for a message that may concern any setting.
+func (found secrets) redactAll(message string) string {
+	for section, settings := range found {
+		for key := range settings {
+			message = found.redact(section, key, message)
+		}
+	}
+
+	return message
+}
+
+func expand(s string, at Origin) (string, []secret, error) {
+	var expanded strings.Builder
+	var resolved []secret
+
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			expanded.WriteString(s)
+			return expanded.String(), resolved, nil
+		}
+
+		if i > 0 && s[i-1] == '$' {
+			expanded.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", nil, _error("unterminated reference " + s[i:])
+		}
+
+		value, err := resolveReference(s[i+2:i+end], at)
+		if err != nil {
+			return "", nil, err
+		}
+
+		resolved = append(resolved, secret{value: value, ref: s[i : i+end+1]})
+		expanded.WriteString(s[:i] + value)
+		s = s[i+end+1:]
+	}
+}
+
+func resolveReference(ref string, at Origin) (string, error) {
+	if strings.HasPrefix(ref, "file:") {
+		path := strings.TrimPrefix(ref, "file:")
+		if path == "" {
+			return "", _error("${file:} requires a path")
+		}
+
+		if !filepath.IsAbs(path) && filepath.IsAbs(at.File) {
+			path = filepath.Join(filepath.Dir(at.File), path)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if ref == "" {
+		return "", _error("empty reference ${}")
+	}
+
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", _error("${" + ref + "} is not set in the environment")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolation(t *testing.T) {
+	dir := t.TempDir()
+
+	os.Setenv("CHEESED_TEST_PORT", "8443")
+	defer os.Unsetenv("CHEESED_TEST_PORT")
+
+	writeConfigFile(dir, "secrets/foo.pem", "/etc/foo.crt,/etc/foo.key\n", t)
+	writeConfigFile(dir, "cheesed.ini", `[cheesed]
+address    = 127.0.0.1:${CHEESED_TEST_PORT}
+passphrase = env:$${KEY_PASSPHRASE}
+sniadapter = inmemory
+
+[inmemory]
+foo.example.com = ${file:secrets/foo.pem}
+`, t)
+
+	config := NewConfig()
+	if err := config.Load(filepath.Join(dir, "cheesed.ini")); err != nil {
+		t.Fatalf("Error loading config: %s", err.Error())
+	}
+
+	assertEqual(config.Address, "127.0.0.1:8443", "Address", t)
+	assertEqual(config.Passphrase, "env:${KEY_PASSPHRASE}", "Passphrase", t)
+	assertEqual(config.SNIAdapterConfig["foo.example.com"], "/etc/foo.crt,/etc/foo.key", "adapter", t)
+
+	writeConfigFile(dir, "undefined.ini", `[cheesed]
+address = ${CHEESED_TEST_UNDEFINED}:443
+
+[inmemory]
+foo.example.com = ${file:missing.pem}
+`, t)
+
+	err := NewConfig().Load(filepath.Join(dir, "undefined.ini"))
+	if err == nil {
+		t.Fatal("Load did not catch undefined references")
+	}
+
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], filepath.Join(dir, "undefined.ini")+":2: [cheesed] address: ${CHEESED_TEST_UNDEFINED} is not set") ||
+		!strings.HasPrefix(lines[1], filepath.Join(dir, "undefined.ini")+":5: [inmemory] foo.example.com: open "+filepath.Join(dir, "missing.pem")) {
+		t.Fatalf("Unexpected interpolation errors:\n%s", err.Error())
+	}
+
+	if _, _, err = expand("${unterminated", Origin{}); err == nil {
+		t.Fatal("expand did not catch an unterminated reference")
+	}
+}
+
+func TestInterpolationRedacted(t *testing.T) {
+	dir := t.TempDir()
+
+	os.Setenv("CHEESED_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("CHEESED_TEST_SECRET")
+
+	writeConfigFile(dir, "secret.txt", "correct horse\n", t)
+	writeConfigFile(dir, "cheesed.ini", `[cheesed]
+minversion = ${CHEESED_TEST_SECRET}
+type       = tcp-${file:secret.txt}
+`, t)
+
+	err := NewConfig().Load(filepath.Join(dir, "cheesed.ini"))
+	if err == nil {
+		t.Fatal("Load did not catch invalid values")
+	}
+
+	if strings.Contains(err.Error(), "hunter2") || strings.Contains(err.Error(), "correct horse") {
+		t.Fatalf("Interpolated values leaked into the error:\n%s", err.Error())
+	}
+
+	if !strings.Contains(err.Error(), "${CHEESED_TEST_SECRET}") {
+		t.Fatalf("The reference is missing from the error:\n%s", err.Error())
+	}
+}
@@ -6,9 +6,17 @@ import (
 )
 
 type Listener struct {
-	inner    net.Listener
-	incoming chan net.Conn
-	closed   bool
+	inner      net.Listener
+	incoming   chan net.Conn
+	closed     bool
+	unknownSNI string
+}
+
+// listenerConn carries the unknown SNI policy of the listener that accepted
+// it.
+type listenerConn struct {
+	net.Conn
+	unknownSNI string
 }
 
 func NewListener(ltype, laddr string, incoming chan net.Conn) (lst *Listener, err error) {
@@ -49,6 +57,10 @@ func (lst *Listener) Run() (err error) {
 			return err
 		}
 
+		if lst.unknownSNI != "" {
+			conn = &listenerConn{Conn: conn, unknownSNI: lst.unknownSNI}
+		}
+
 		lst.incoming <- conn
 	}
 
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+type Policy struct {
+	MinVersion            uint16
+	MaxVersion            uint16
+	CipherSuites          []uint16
+	CurvePreferences      []tls.CurveID
+	DisableSessionTickets *bool
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	tlsCurves = map[string]tls.CurveID{
+		"p256":               tls.CurveP256,
+		"p384":               tls.CurveP384,
+		"p521":               tls.CurveP521,
+		"x25519":             tls.X25519,
+		"x25519mlkem768":     tls.X25519MLKEM768,
+		"secp256r1mlkem768":  tls.SecP256r1MLKEM768,
+		"secp384r1mlkem1024": tls.SecP384r1MLKEM1024,
+	}
+)
+
+func ParsePolicy(settings map[string]string) (policy *Policy, err error) {
+	policy = new(Policy)
+
+	if s, found := settings["minversion"]; found {
+		if policy.MinVersion, err = parseVersion(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, found := settings["maxversion"]; found {
+		if policy.MaxVersion, err = parseVersion(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, found := settings["ciphersuites"]; found {
+		if policy.CipherSuites, err = parseCipherSuites(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, found := settings["curves"]; found {
+		if policy.CurvePreferences, err = parseCurves(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, found := settings["sessiontickets"]; found {
+		enabled, err := parseBool("sessiontickets", s)
+		if err != nil {
+			return nil, err
+		}
+
+		disabled := !enabled
+		policy.DisableSessionTickets = &disabled
+	}
+
+	return policy, nil
+}
+
+func (policy *Policy) Merge(parent *Policy) *Policy {
+	merged := *policy
+
+	if parent == nil {
+		return &merged
+	}
+
+	if merged.MinVersion == 0 {
+		merged.MinVersion = parent.MinVersion
+	}
+	if merged.MaxVersion == 0 {
+		merged.MaxVersion = parent.MaxVersion
+	}
+	if merged.CipherSuites == nil {
+		merged.CipherSuites = parent.CipherSuites
+	}
+	if merged.CurvePreferences == nil {
+		merged.CurvePreferences = parent.CurvePreferences
+	}
+	if merged.DisableSessionTickets == nil {
+		merged.DisableSessionTickets = parent.DisableSessionTickets
+	}
+
+	return &merged
+}
+
+func (policy *Policy) Verify() error {
+	if policy.MinVersion != 0 && policy.MaxVersion != 0 && policy.MinVersion > policy.MaxVersion {
+		return _error("minversion " + tls.VersionName(policy.MinVersion) + " is above maxversion " + tls.VersionName(policy.MaxVersion))
+	}
+
+	if len(policy.CipherSuites) > 0 && policy.MinVersion == tls.VersionTLS13 {
+		return _error("ciphersuites cannot be configured when minversion is TLS 1.3")
+	}
+
+	if len(policy.CurvePreferences) > 0 && policy.MaxVersion != 0 && policy.MaxVersion < tls.VersionTLS13 {
+		for _, curve := range policy.CurvePreferences {
+			if !isHybridCurve(curve) {
+				return nil
+			}
+		}
+
+		return _error("hybrid post-quantum curves require maxversion 1.3")
+	}
+
+	return nil
+}
+
+func (policy *Policy) Apply(config *tls.Config) {
+	if policy.MinVersion != 0 {
+		config.MinVersion = policy.MinVersion
+	}
+	if policy.MaxVersion != 0 {
+		config.MaxVersion = policy.MaxVersion
+	}
+	if policy.CipherSuites != nil {
+		config.CipherSuites = policy.CipherSuites
+	}
+	if policy.CurvePreferences != nil {
+		config.CurvePreferences = policy.CurvePreferences
+	}
+	if policy.DisableSessionTickets != nil {
+		config.SessionTicketsDisabled = *policy.DisableSessionTickets
+	}
+}
+
+func parseVersion(s string) (uint16, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	name = strings.TrimPrefix(name, "tls")
+	name = strings.TrimSpace(name)
+
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, _error("Unknown TLS version: " + s)
+	}
+
+	return version, nil
+}
+
+func parseCipherSuites(s string) (ids []uint16, err error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	for _, name := range SplitList(s) {
+		id, ok := suites[strings.ToUpper(name)]
+		if !ok {
+			return nil, _error("Unknown or insecure cipher suite: " + name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func parseCurves(s string) (curves []tls.CurveID, err error) {
+	for _, name := range SplitList(s) {
+		key := strings.ToLower(strings.Replace(name, "-", "", -1))
+
+		curve, ok := tlsCurves[key]
+		if !ok {
+			return nil, _error("Unknown curve: " + name)
+		}
+
+		curves = append(curves, curve)
+	}
+
+	return curves, nil
+}
+
+func isHybridCurve(curve tls.CurveID) bool {
+	switch curve {
+	case tls.X25519MLKEM768, tls.SecP256r1MLKEM768, tls.SecP384r1MLKEM1024:
+		return true
+	}
+
+	return false
+}
+
+// SplitList splits a comma separated value, dropping blank items.
+func SplitList(s string) (items []string) {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
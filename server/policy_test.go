@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy(map[string]string{
+		"minversion":     "1.2",
+		"maxversion":     "TLS1.3",
+		"ciphersuites":   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"curves":         "X25519MLKEM768, X25519, P-256",
+		"sessiontickets": "off",
+	})
+
+	if err != nil {
+		t.Fatalf("Error parsing policy: %s", err.Error())
+	}
+
+	if policy.MinVersion != tls.VersionTLS12 || policy.MaxVersion != tls.VersionTLS13 {
+		t.Fatalf("Unexpected versions: %x-%x", policy.MinVersion, policy.MaxVersion)
+	}
+
+	if len(policy.CipherSuites) != 2 || policy.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("Unexpected cipher suites: %v", policy.CipherSuites)
+	}
+
+	if len(policy.CurvePreferences) != 3 || policy.CurvePreferences[0] != tls.X25519MLKEM768 {
+		t.Fatalf("Unexpected curves: %v", policy.CurvePreferences)
+	}
+
+	if policy.DisableSessionTickets == nil || !*policy.DisableSessionTickets {
+		t.Fatal("Session tickets were not disabled")
+	}
+
+	invalid := []map[string]string{
+		{"minversion": "1.4"},
+		{"ciphersuites": "TLS_RSA_WITH_RC4_128_SHA"},
+		{"curves": "P-192"},
+		{"sessiontickets": "maybe"},
+	}
+
+	for _, settings := range invalid {
+		if _, err := ParsePolicy(settings); err == nil {
+			t.Fatalf("ParsePolicy did not catch invalid settings: %v", settings)
+		}
+	}
+}
+
+func TestPolicyVerify(t *testing.T) {
+	policy := &Policy{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}
+	if policy.Verify() == nil {
+		t.Fatal("Verify did not catch minversion above maxversion")
+	}
+
+	policy = &Policy{MinVersion: tls.VersionTLS13, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}
+	if policy.Verify() == nil {
+		t.Fatal("Verify did not catch cipher suites with TLS 1.3 only")
+	}
+
+	policy = &Policy{MaxVersion: tls.VersionTLS12, CurvePreferences: []tls.CurveID{tls.X25519MLKEM768}}
+	if policy.Verify() == nil {
+		t.Fatal("Verify did not catch hybrid curves without TLS 1.3")
+	}
+
+	policy = &Policy{MaxVersion: tls.VersionTLS12, CurvePreferences: []tls.CurveID{tls.X25519MLKEM768, tls.X25519}}
+	if err := policy.Verify(); err != nil {
+		t.Fatalf("Verify rejected a valid policy: %s", err.Error())
+	}
+}
+
+func TestPolicyMerge(t *testing.T) {
+	disabled := true
+
+	global := &Policy{
+		MinVersion:            tls.VersionTLS12,
+		CurvePreferences:      []tls.CurveID{tls.X25519},
+		DisableSessionTickets: &disabled,
+	}
+
+	host := &Policy{MinVersion: tls.VersionTLS13}
+
+	merged := host.Merge(global)
+
+	if merged.MinVersion != tls.VersionTLS13 {
+		t.Fatal("Host minversion was overridden by the global policy")
+	}
+
+	if len(merged.CurvePreferences) != 1 || !*merged.DisableSessionTickets {
+		t.Fatal("Host policy did not inherit global settings")
+	}
+
+	config := new(tls.Config)
+	merged.Apply(config)
+
+	if config.MinVersion != tls.VersionTLS13 || !config.SessionTicketsDisabled {
+		t.Fatal("Policy was not applied to the tls config")
+	}
+}
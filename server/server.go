@@ -5,19 +5,61 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/benburkert/cheeseman/signer"
 	"github.com/benburkert/cheeseman/sni"
+	"github.com/benburkert/cheeseman/staple"
 )
 
 type Server struct {
-	connections chan net.Conn
-	log         *log.Logger
-	listener    *Listener
-	certificate tls.Certificate
-	tlsConfig   *tls.Config
-	sniAdapter  sni.Adapter
+	connections     chan net.Conn
+	log             *log.Logger
+	listener        *Listener
+	extraListeners  []*Listener
+	certificate     tls.Certificate
+	tlsConfig       *tls.Config
+	hostConfigs     map[string]*tls.Config
+	sniAdapter      sni.Adapter
+	hosts           map[string]*HostConfig
+	backends        []string
+	unknownSNI      string
+	fallback        []string
+	destinations    map[string]string
+	rejectConfig    *tls.Config
+	routes          map[net.Conn]*route
+	routesLock      sync.Mutex
+	tickets         *ticketKeys
+	stapler         *staple.Stapler
+	signer          *signer.Client
+	keys            sni.KeySources
+	peek            bool
+	admin           *http.Server
+	adminListener   net.Listener
+	control         *http.Server
+	controlListener net.Listener
+	adapterName     string
+	started         time.Time
+	active          sync.WaitGroup
+	grace           int64
+	stopOnce        sync.Once
+	sniProtos       []string
+	signals         chan os.Signal
+	done            chan struct{}
+}
+
+type route struct {
+	serverName string
+	known      bool
+	backends   []string
+	since      time.Time
 }
 
 func NewServer(config *Config) (srv *Server) {
@@ -30,30 +72,207 @@ func (srv *Server) Start() {
 	go srv.Run()
 }
 
-func (srv *Server) Run() {
+// Run serves until Stop is called or a listener fails, then drains
+// connections and returns the first listener error.
+func (srv *Server) Run() error {
 	go func() {
 		for {
 			conn := <-srv.connections
+			srv.active.Add(1)
 			go srv.handle(conn)
 		}
 	}()
 
+	signal.Notify(srv.signals, syscall.SIGHUP)
+	go srv.handleSignals()
+
+	if srv.tickets != nil && srv.tickets.rotation > 0 {
+		go srv.rotateTicketKeys()
+	}
+
+	if srv.stapler != nil {
+		srv.trackStaples()
+		go srv.stapler.Run(srv.done)
+	}
+
+	if srv.admin != nil {
+		go srv.admin.Serve(srv.adminListener)
+	}
+
+	if srv.control != nil {
+		go srv.control.Serve(srv.controlListener)
+	}
+
+	failures := make(chan error, len(srv.extraListeners))
+
+	for _, listener := range srv.extraListeners {
+		go func(listener *Listener) {
+			if err := listener.Run(); err != nil {
+				srv._error(err.Error())
+				failures <- err
+			}
+		}(listener)
+	}
+
+	srv.started = time.Now()
+
 	err := srv.listener.Run()
 
-	if err != nil {
-		srv._error(err.Error())
+	srv.drain(time.Duration(atomic.LoadInt64(&srv.grace)))
+
+	if err == nil {
+		select {
+		case err = <-failures:
+		default:
+		}
 	}
+
+	return err
 }
 
 func (srv *Server) Stop() {
-	srv.listener.Stop()
+	srv.stopOnce.Do(func() {
+		signal.Stop(srv.signals)
+		close(srv.done)
+
+		if srv.admin != nil {
+			srv.admin.Close()
+		}
+
+		if srv.control != nil {
+			srv.control.Close()
+		}
+
+		for _, listener := range srv.extraListeners {
+			listener.Stop()
+		}
+
+		srv.listener.Stop()
+
+		if closer, ok := srv.sniAdapter.(sni.Closer); ok {
+			if err := closer.Close(); err != nil {
+				srv._error(err.Error())
+			}
+		}
+	})
+}
+
+// Shutdown stops accepting connections and lets Run wait up to grace for
+// proxied connections to finish.
+func (srv *Server) Shutdown(grace time.Duration) {
+	atomic.StoreInt64(&srv.grace, int64(grace))
+
+	srv.Stop()
+}
+
+func (srv *Server) drain(grace time.Duration) {
+	if grace <= 0 {
+		return
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		srv.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(grace):
+		srv._error("Shutting down with connections still open")
+	}
+}
+
+func (srv *Server) Reload() error {
+	if reloader, ok := srv.sniAdapter.(sni.Reloader); ok {
+		if err := reloader.Reload(); err != nil {
+			return err
+		}
+	}
+
+	if srv.tickets != nil {
+		if err := srv.tickets.load(); err != nil {
+			return err
+		}
+
+		srv.applyTicketKeys()
+	}
+
+	srv.trackStaples()
+
+	return nil
+}
+
+// trackStaples prefetches OCSP responses for the default certificate and
+// every certificate the adapter can list.
+func (srv *Server) trackStaples() {
+	if srv.stapler == nil {
+		return
+	}
+
+	certs := []*tls.Certificate{&srv.certificate}
+
+	if enumerator, ok := srv.sniAdapter.(sni.Enumerator); ok {
+		for _, cert := range enumerator.Certificates() {
+			certs = append(certs, cert)
+		}
+	}
+
+	go srv.stapler.Track(certs)
+}
+
+func (srv *Server) handleSignals() {
+	for {
+		select {
+		case <-srv.signals:
+			if err := srv.Reload(); err != nil {
+				srv._error(err.Error())
+			}
+		case <-srv.done:
+			return
+		}
+	}
+}
+
+func (srv *Server) rotateTicketKeys() {
+	for {
+		select {
+		case <-time.After(srv.tickets.next(time.Now())):
+			srv.applyTicketKeys()
+		case <-srv.done:
+			return
+		}
+	}
+}
+
+func (srv *Server) applyTicketKeys() {
+	keys := srv.tickets.keys(time.Now())
+
+	srv.tlsConfig.SetSessionTicketKeys(keys)
+	for _, hostConfig := range srv.hostConfigs {
+		hostConfig.SetSessionTicketKeys(keys)
+	}
 }
 
 func (srv *Server) handle(inner net.Conn) {
+	defer srv.active.Done()
+
 	conn := tls.Server(inner, srv.tlsConfig)
 	defer conn.Close()
 
-	conn.Handshake()
+	defer srv.untrackRoute(inner)
+
+	err := conn.Handshake()
+	rt := srv.route(inner)
+
+	if err != nil || rt == nil || len(rt.backends) == 0 {
+		return
+	}
+
+	err = proxy(conn, rt.backends)
+	if err != nil {
+		srv._error(err.Error())
+	}
 }
 
 func (srv *Server) setup(config *Config) {
@@ -64,7 +283,7 @@ func (srv *Server) setup(config *Config) {
 	case "stdout":
 		logWriter = os.Stdout
 	default:
-		logWriter, err = os.OpenFile(config.Log, os.O_APPEND, 0666)
+		logWriter, err = os.OpenFile(config.Log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 
 		if err != nil {
 			panic(err.Error())
@@ -72,6 +291,7 @@ func (srv *Server) setup(config *Config) {
 	}
 
 	srv.log = log.New(logWriter, "cheesed", os.O_APPEND)
+	sni.SetLogger(srv.log)
 
 	srv.connections = make(chan net.Conn, 1024)
 
@@ -80,27 +300,296 @@ func (srv *Server) setup(config *Config) {
 		srv._fatal(err.Error())
 	}
 
-	srv.certificate, err = tls.LoadX509KeyPair(config.Certificate, config.Key)
-	if err != nil {
+	for _, listener := range config.Listeners {
+		extra, err := NewListener(listener.Type, listener.Address, srv.connections)
+		if err != nil {
+			srv._fatal(err.Error())
+		}
+
+		extra.unknownSNI = listener.UnknownSNI
+		srv.extraListeners = append(srv.extraListeners, extra)
+	}
+
+	if err = srv.configure(config); err != nil {
 		srv._fatal(err.Error())
 	}
 
-	srv.sniAdapter, err = sni.NewAdapter(config.SNIAdapterName, config.SNIAdapterConfig)
+	if config.Admin != "" {
+		srv.adminListener, err = listenAdmin(config.Admin)
+		if err != nil {
+			srv._fatal(err.Error())
+		}
+
+		srv.admin = &http.Server{Handler: srv.adminHandler()}
+	}
+
+	if config.Control != "" {
+		srv.controlListener, err = listenUnix(config.Control)
+		if err != nil {
+			srv._fatal(err.Error())
+		}
+
+		srv.control = &http.Server{Handler: srv.controlHandler()}
+	}
+}
+
+// setupKeys applies the settings config needs before its certificates and
+// adapter can be loaded: the passphrase source, the state directory and the
+// signer. It returns the adapter config and the key sources for the default
+// certificate.
+func setupKeys(config *Config) (map[string]string, sni.KeySources) {
+	sni.SetPassphrase(config.Passphrase)
+	sni.SetStateDir(config.State)
+
+	adapterConfig := config.adapterSettings()
+	return adapterConfig, sni.NewKeySources(adapterConfig)
+}
+
+// configure builds everything the server needs to select certificates,
+// policies and backends, without listening.
+func (srv *Server) configure(config *Config) (err error) {
+	var adapterConfig map[string]string
+	adapterConfig, srv.keys = setupKeys(config)
+
+	if config.Signer != "" {
+		srv.signer = sni.SignerClient(config.Signer, config.SignerTimeout)
+	}
+
+	srv.certificate, err = srv.keys.LoadX509KeyPair(config.Certificate, config.Key)
 	if err != nil {
-		srv._fatal(err.Error())
+		return err
+	}
+
+	srv.sniAdapter, err = sni.NewAdapter(config.SNIAdapterName, adapterConfig)
+	if err != nil {
+		return err
 	}
 
+	if journal, ok := srv.sniAdapter.(*sni.JournalAdapter); ok {
+		for _, name := range journal.Conflicts() {
+			srv._error("Static config for " + name + " overrides its runtime certificate")
+		}
+	}
+
+	if protocols, ok := srv.sniAdapter.(sni.Protocols); ok {
+		srv.sniProtos = protocols.NextProtos()
+	}
+
+	srv.adapterName = config.SNIAdapterName
+	srv.hosts = config.Hosts
+	srv.backends = config.Backends
+	srv.unknownSNI = config.UnknownSNI
+	srv.fallback = config.Fallback
+	srv.destinations = config.Destinations
+	srv.routes = make(map[net.Conn]*route)
+	srv.signals = make(chan os.Signal, 1)
+	srv.done = make(chan struct{})
+
 	srv.tlsConfig = &tls.Config{
-		Certificates:   []tls.Certificate{srv.certificate},
-		GetCertificate: srv.sniCallback,
+		Certificates: []tls.Certificate{srv.certificate},
+	}
+	config.Policy.Apply(srv.tlsConfig)
+
+	srv.rejectConfig = &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return nil, nil
+		},
+	}
+
+	srv.hostConfigs = make(map[string]*tls.Config)
+	for name, host := range config.Hosts {
+		hostConfig := srv.tlsConfig.Clone()
+		host.Policy.Apply(hostConfig)
+
+		srv.hostConfigs[name] = hostConfig
+	}
+
+	if config.OCSP {
+		srv.stapler = staple.NewStapler(config.OCSPCache, config.OCSPResponder, config.OCSPMustStaple)
+		srv.stapler.Log = srv.log
+	}
+
+	if config.TicketKeys != "" || config.TicketRotation > 0 {
+		srv.tickets, err = newTicketKeys(config.TicketKeys, config.TicketRotation, config.TicketOverlap)
+		if err != nil {
+			return err
+		}
+
+		srv.applyTicketKeys()
 	}
 
+	srv.tlsConfig.GetConfigForClient = srv.configForClient
+
+	return nil
 }
 
 func (srv *Server) sniCallback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if srv.peek {
+		return sni.Peek(srv.sniAdapter, hello)
+	}
+
 	return srv.sniAdapter.Callback(hello)
 }
 
+func (srv *Server) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	tlsConfig, rt, err := srv.selectConfig(hello)
+	if err != nil {
+		return nil, err
+	}
+
+	if rt != nil {
+		srv.trackRoute(hello.Conn, rt)
+	}
+
+	return tlsConfig, nil
+}
+
+// selectConfig picks the certificate, policy and backends for hello. A nil
+// route means the connection is rejected.
+func (srv *Server) selectConfig(hello *tls.ClientHelloInfo) (*tls.Config, *route, error) {
+	name := strings.ToLower(hello.ServerName)
+	policy := srv.unknownSNIPolicy(hello.Conn)
+
+	if name == "" && policy == UnknownSNIDestination {
+		name = srv.destinations[localIP(hello.Conn)]
+	}
+
+	var cert *tls.Certificate
+	if name != "" {
+		named := *hello
+		named.ServerName = name
+
+		var err error
+		cert, err = srv.sniCallback(&named)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cert == nil {
+		if policy == UnknownSNIReject {
+			return srv.rejectConfig, nil, nil
+		}
+
+		rt := srv.unknownRoute(name, policy)
+
+		if srv.stapler == nil {
+			return srv.tlsConfig, rt, nil
+		}
+
+		tlsConfig, err := srv.certificateConfig(srv.tlsConfig, &srv.certificate)
+		return tlsConfig, rt, err
+	}
+
+	rt := &route{serverName: name, known: true, backends: srv.backends}
+	if host, ok := srv.hosts[name]; ok && len(host.Backends) > 0 {
+		rt.backends = host.Backends
+	}
+
+	proto := sniProtocol(hello.SupportedProtos, srv.sniProtos)
+	if proto != "" {
+		rt.backends = nil
+	}
+
+	hostConfig, ok := srv.hostConfigs[name]
+	if !ok {
+		hostConfig = srv.tlsConfig
+	}
+
+	if proto == "" {
+		tlsConfig, err := srv.certificateConfig(hostConfig, cert)
+		return tlsConfig, rt, err
+	}
+
+	tlsConfig := hostConfig.Clone()
+	tlsConfig.Certificates = []tls.Certificate{*cert}
+	tlsConfig.NextProtos = []string{proto}
+
+	return tlsConfig, rt, nil
+}
+
+func (srv *Server) certificateConfig(base *tls.Config, cert *tls.Certificate) (*tls.Config, error) {
+	if srv.stapler != nil {
+		var err error
+
+		cert, err = srv.stapler.Staple(cert)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig := base.Clone()
+	tlsConfig.Certificates = []tls.Certificate{*cert}
+
+	return tlsConfig, nil
+}
+
+// unknownSNIPolicy is the policy of the listener that accepted conn, or the
+// global policy.
+func (srv *Server) unknownSNIPolicy(conn net.Conn) string {
+	if lc, ok := conn.(*listenerConn); ok {
+		return lc.unknownSNI
+	}
+
+	return srv.unknownSNI
+}
+
+func (srv *Server) unknownRoute(name, policy string) *route {
+	rt := &route{serverName: name, backends: srv.backends}
+
+	if policy == UnknownSNIFallback {
+		rt.backends = srv.fallback
+	}
+
+	return rt
+}
+
+func (srv *Server) trackRoute(conn net.Conn, rt *route) {
+	srv.routesLock.Lock()
+	defer srv.routesLock.Unlock()
+
+	rt.since = time.Now()
+	srv.routes[conn] = rt
+}
+
+func (srv *Server) route(conn net.Conn) *route {
+	srv.routesLock.Lock()
+	defer srv.routesLock.Unlock()
+
+	return srv.routes[conn]
+}
+
+func (srv *Server) untrackRoute(conn net.Conn) {
+	srv.routesLock.Lock()
+	defer srv.routesLock.Unlock()
+
+	delete(srv.routes, conn)
+}
+
+func sniProtocol(offered, handled []string) string {
+	for _, proto := range handled {
+		for _, offer := range offered {
+			if proto == offer {
+				return proto
+			}
+		}
+	}
+
+	return ""
+}
+
+func localIP(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+
+	return ""
+}
+
 func (srv *Server) _error(message string) {
 	srv.log.Print(message)
 }
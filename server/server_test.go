@@ -246,3 +246,56 @@ WhXudo1UBysfBu4EWopmQDgEdKFVs4eh2b1xqw4f
 -----END RSA PRIVATE KEY-----
 `
 )
+
+func TestExtraListener(t *testing.T) {
+	config := testConfig(t)
+	config.Listeners = []*ListenerConfig{{Type: "unix", Address: config.Address + ".2"}}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	cli := tls.Client(unixConn(config.Listeners[0].Address, t), &tls.Config{InsecureSkipVerify: true})
+	defer cli.Close()
+
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Error during handshake on the extra listener: %s", err.Error())
+	}
+}
+
+func TestRunListenerFailure(t *testing.T) {
+	srv := NewServer(testConfig(t))
+	defer srv.Stop()
+
+	// closing the socket under the listener, unlike Stop, is a failure
+	srv.listener.inner.Close()
+
+	if err := srv.Run(); err == nil {
+		t.Fatal("Run did not return the listener failure")
+	}
+}
+
+func TestListenerUnknownSNI(t *testing.T) {
+	config := testConfig(t)
+	config.Listeners = []*ListenerConfig{{Type: "unix", Address: config.Address + ".2", UnknownSNI: UnknownSNIReject}}
+
+	srv := NewServer(config)
+	defer srv.Stop()
+	srv.Start()
+
+	unknown := &tls.Config{ServerName: "unknown.example.org", InsecureSkipVerify: true}
+
+	cli := tls.Client(unixConn(config.Address, t), unknown)
+	defer cli.Close()
+
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Main listener did not use the default policy: %s", err.Error())
+	}
+
+	cli = tls.Client(unixConn(config.Listeners[0].Address, t), unknown)
+	defer cli.Close()
+
+	if cli.Handshake() == nil {
+		t.Fatal("Extra listener did not reject an unknown server name")
+	}
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadStructured reads filePath as TOML, YAML or JSON by extension, and
+// flattens it into the sections INI would have:
+//
+//	cheesed:
+//	  address: 0.0.0.0:443
+//	  backend: [10.0.0.1:80, 10.0.0.2:80]
+//	  listeners:
+//	    - {type: tcp6, address: "[::]:443"}
+//	  policy: {minversion: "1.2", curves: [x25519, p256]}
+//	hosts:
+//	  foo.example.com: {backend: [unix:/run/foo.sock], policy: {minversion: "1.3"}}
+//	inmemory:
+//	  foo.example.com: [/etc/cheesed/foo.crt, /etc/cheesed/foo.key]
+//	include: [conf.d/*.yaml]
+//
+// Lists become comma separated values, policy tables are merged into their
+// section and each entry under hosts becomes a [host:<name>] section. Scalars
+// keep the text they were written with where the format allows, so a YAML
+// 1.0 stays 1.0. The line of each section and setting is returned with the
+// dict, keyed like it, and the include patterns are returned separately.
+func loadStructured(filePath string) (Dict, map[string]map[string]int, []string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var tree map[string]interface{}
+	var lines map[string]int
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".toml":
+		if _, err = toml.Decode(string(data), &tree); err != nil {
+			return nil, nil, nil, err
+		}
+
+		lines = tomlLines(data)
+	case ".yaml", ".yml":
+		tree, lines, err = decodeYAML(data)
+	default:
+		tree, lines, err = decodeJSON(data)
+	}
+
+	if err != nil {
+		return nil, nil, nil, _error(filePath + ": " + err.Error())
+	}
+
+	var includes []string
+
+	if value, ok := tree["include"]; ok {
+		s, err := flattenValue(value)
+		if err != nil {
+			return nil, nil, nil, _error(filePath + ": include: " + err.Error())
+		}
+
+		includes = SplitList(s)
+		delete(tree, "include")
+	}
+
+	flattener := &flattener{dict: make(Dict), lines: lines, origins: make(map[string]map[string]int)}
+
+	if err = flattener.config(tree); err != nil {
+		return nil, nil, nil, _error(filePath + ": " + err.Error())
+	}
+
+	return flattener.dict, flattener.origins, includes, nil
+}
+
+// flattener turns a decoded tree into a Dict, finding the line of every
+// section and setting by its dotted path in the tree.
+type flattener struct {
+	dict    Dict
+	lines   map[string]int
+	origins map[string]map[string]int
+}
+
+func (fl *flattener) config(tree map[string]interface{}) error {
+	for name, value := range tree {
+		table, ok := asTable(value)
+		if !ok {
+			return _error("Section " + name + " must be a table")
+		}
+
+		if strings.ToLower(name) != "hosts" {
+			if err := fl.section(strings.ToLower(name), name, table); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for host, value := range table {
+			hostTable, ok := asTable(value)
+			if !ok {
+				return _error("Host " + host + " must be a table")
+			}
+
+			if err := fl.section("host:"+strings.ToLower(host), joinPath(name, host), hostTable); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fl *flattener) section(name, path string, table map[string]interface{}) error {
+	if fl.dict[name] == nil {
+		fl.dict[name] = make(map[string]string)
+		fl.origins[name] = map[string]int{"": fl.line(path)}
+	}
+
+	return fl.settings(name, path, table)
+}
+
+func (fl *flattener) settings(name, path string, table map[string]interface{}) error {
+	section := fl.dict[name]
+
+	for key, value := range table {
+		keyPath := joinPath(path, key)
+		key = strings.ToLower(key)
+
+		switch key {
+		case "policy":
+			policy, ok := asTable(value)
+			if !ok {
+				return _error("[" + name + "] policy must be a table")
+			}
+
+			if err := fl.settings(name, keyPath, policy); err != nil {
+				return err
+			}
+
+			continue
+		case "listeners":
+			listeners, err := flattenListeners(name, value)
+			if err != nil {
+				return err
+			}
+
+			section[key] = listeners
+		default:
+			s, err := flattenValue(value)
+			if err != nil {
+				return _error("[" + name + "] " + key + ": " + err.Error())
+			}
+
+			section[key] = s
+		}
+
+		fl.origins[name][key] = fl.line(keyPath)
+	}
+
+	return nil
+}
+
+// line is the line path was read from, or that of its closest parent when
+// the format does not say.
+func (fl *flattener) line(path string) int {
+	path = strings.ToLower(path)
+
+	for {
+		if line, ok := fl.lines[path]; ok {
+			return line
+		}
+
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return 0
+		}
+
+		path = path[:i]
+	}
+}
+
+func flattenListeners(name string, value interface{}) (string, error) {
+	items, ok := asList(value)
+	if !ok {
+		return flattenValue(value)
+	}
+
+	var listeners []string
+
+	for _, item := range items {
+		table, ok := asTable(item)
+		if !ok {
+			s, err := flattenValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			listeners = append(listeners, s)
+			continue
+		}
+
+		address, _ := flattenValue(table["address"])
+		if address == "" {
+			return "", _error("[" + name + "] listeners require an address")
+		}
+
+		if network, _ := flattenValue(table["type"]); network != "" {
+			address = network + ":" + address
+		}
+
+		if policy, _ := flattenValue(table["unknownsni"]); policy != "" {
+			address += " unknownsni=" + policy
+		}
+
+		listeners = append(listeners, address)
+	}
+
+	return strings.Join(listeners, ","), nil
+}
+
+func flattenValue(value interface{}) (string, error) {
+	if items, ok := asList(value); ok {
+		flattened := make([]string, 0, len(items))
+
+		for _, item := range items {
+			s, err := flattenValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			flattened = append(flattened, s)
+		}
+
+		return strings.Join(flattened, ","), nil
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case float64:
+		// TOML floats arrive decoded, keep the point a version number
+		// was written with
+		s := strconv.FormatFloat(value, 'f', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+
+		return s, nil
+	case bool, int, int64, uint64:
+		return fmt.Sprint(value), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		return "", _error("unexpected table with keys " + strings.Join(keys, ", "))
+	}
+
+	return "", _error(fmt.Sprintf("unsupported value %v", value))
+}
+
+// asTable and asList accept the shapes decoders produce for tables and
+// lists, including TOML's arrays of tables.
+func asTable(value interface{}) (map[string]interface{}, bool) {
+	table, ok := value.(map[string]interface{})
+	return table, ok
+}
+
+func asList(value interface{}) ([]interface{}, bool) {
+	switch value := value.(type) {
+	case []interface{}:
+		return value, true
+	case []map[string]interface{}:
+		items := make([]interface{}, len(value))
+		for i, table := range value {
+			items[i] = table
+		}
+
+		return items, true
+	}
+
+	return nil, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return strings.ToLower(key)
+	}
+
+	return path + "." + strings.ToLower(key)
+}
+
+// decodeYAML decodes data keeping scalars as written, along with the line of
+// every key.
+func decodeYAML(data []byte) (map[string]interface{}, map[string]int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	lines := make(map[string]int)
+	if len(doc.Content) == 0 {
+		return map[string]interface{}{}, lines, nil
+	}
+
+	value, err := yamlValue(doc.Content[0], "", lines)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, _error("expected a table at the top level")
+	}
+
+	return tree, lines, nil
+}
+
+func yamlValue(node *yaml.Node, path string, lines map[string]int) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlValue(node.Alias, path, lines)
+	case yaml.MappingNode:
+		table := make(map[string]interface{})
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			keyPath := joinPath(path, key)
+
+			value, err := yamlValue(node.Content[i+1], keyPath, lines)
+			if err != nil {
+				return nil, err
+			}
+
+			table[key] = value
+			lines[keyPath] = node.Content[i].Line
+		}
+
+		return table, nil
+	case yaml.SequenceNode:
+		items := make([]interface{}, 0, len(node.Content))
+
+		for _, item := range node.Content {
+			value, err := yamlValue(item, path, lines)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, value)
+		}
+
+		return items, nil
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil, nil
+		}
+
+		return node.Value, nil
+	}
+
+	return nil, _error(fmt.Sprintf("line %d: unsupported value", node.Line))
+}
+
+// decodeJSON decodes data keeping numbers as written, along with the line of
+// every key.
+func decodeJSON(data []byte) (map[string]interface{}, map[string]int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	lines := make(map[string]int)
+
+	value, err := jsonValue(decoder, data, "", lines)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, nil, _error("unexpected data after the top level value")
+	}
+
+	tree, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, _error("expected an object at the top level")
+	}
+
+	return tree, lines, nil
+}
+
+func jsonValue(decoder *json.Decoder, data []byte, path string, lines map[string]int) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		table := make(map[string]interface{})
+
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			key := token.(string)
+			keyPath := joinPath(path, key)
+			lines[keyPath] = bytes.Count(data[:decoder.InputOffset()], []byte("\n")) + 1
+
+			if table[key], err = jsonValue(decoder, data, keyPath, lines); err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = decoder.Token()
+		return table, err
+	case json.Delim('['):
+		items := make([]interface{}, 0)
+
+		for decoder.More() {
+			item, err := jsonValue(decoder, data, path, lines)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		_, err = decoder.Token()
+		return items, err
+	}
+
+	return token, nil
+}
+
+// tomlLines finds the line of every table header and key in a TOML file by
+// its dotted path. Keys inside inline tables and multi-line values are not
+// found; their settings take the line of the enclosing key.
+func tomlLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	table := ""
+
+	for i, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(text, "["):
+			if end := strings.LastIndex(text, "]"); end > 0 {
+				table = tomlPath(strings.Trim(text[:end+1], "[]"))
+			}
+
+			if _, ok := lines[table]; !ok {
+				lines[table] = i + 1
+			}
+		case strings.Contains(text, "=") && !strings.HasPrefix(text, "#"):
+			path := tomlPath(text[:strings.Index(text, "=")])
+			if table != "" {
+				path = table + "." + path
+			}
+
+			if _, ok := lines[path]; !ok {
+				lines[path] = i + 1
+			}
+		}
+	}
+
+	return lines
+}
+
+// tomlPath turns a TOML key such as hosts."foo.example.com" into a path.
+func tomlPath(key string) string {
+	var parts []string
+	var part strings.Builder
+	var quote rune
+
+	for _, c := range key {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				part.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			parts = append(parts, strings.TrimSpace(part.String()))
+			part.Reset()
+		default:
+			part.WriteRune(c)
+		}
+	}
+
+	parts = append(parts, strings.TrimSpace(part.String()))
+
+	return strings.ToLower(strings.Join(parts, "."))
+}
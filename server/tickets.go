@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ticketKeys struct {
+	path     string
+	rotation time.Duration
+	overlap  int
+	masters  [][32]byte
+	random   [][32]byte
+	epoch    int64
+	lock     sync.Mutex
+}
+
+func newTicketKeys(path string, rotation time.Duration, overlap int) (tk *ticketKeys, err error) {
+	tk = &ticketKeys{
+		path:     path,
+		rotation: rotation,
+		overlap:  overlap,
+	}
+
+	if err = tk.load(); err != nil {
+		return nil, err
+	}
+
+	return tk, nil
+}
+
+func (tk *ticketKeys) load() error {
+	if tk.path == "" {
+		return nil
+	}
+
+	masters, err := loadTicketKeyFile(tk.path)
+	if err != nil {
+		return err
+	}
+
+	tk.lock.Lock()
+	defer tk.lock.Unlock()
+
+	tk.masters = masters
+	return nil
+}
+
+// keys returns the session ticket keys for the rotation period containing
+// now, followed by the next period's key and then older keys. With a key file
+// every node derives the same keys for a period, so sessions resume across
+// instances that share the file. Only the first key encrypts, so the next
+// period's key just lets a node accept tickets from a peer whose clock has
+// already crossed into it.
+func (tk *ticketKeys) keys(now time.Time) (keys [][32]byte) {
+	tk.lock.Lock()
+	defer tk.lock.Unlock()
+
+	if tk.rotation <= 0 {
+		return tk.masters
+	}
+
+	epoch := now.UnixNano() / int64(tk.rotation)
+
+	if len(tk.masters) == 0 {
+		return tk.rotateRandom(epoch)
+	}
+
+	for _, master := range tk.masters {
+		keys = append(keys, deriveTicketKey(master, epoch), deriveTicketKey(master, epoch+1))
+
+		for i := 1; i <= tk.overlap; i++ {
+			keys = append(keys, deriveTicketKey(master, epoch-int64(i)))
+		}
+	}
+
+	return keys
+}
+
+func (tk *ticketKeys) rotateRandom(epoch int64) [][32]byte {
+	if len(tk.random) > 0 && tk.epoch == epoch {
+		return tk.random
+	}
+
+	var key [32]byte
+	rand.Read(key[:])
+
+	tk.random = append([][32]byte{key}, tk.random...)
+	if len(tk.random) > tk.overlap+1 {
+		tk.random = tk.random[:tk.overlap+1]
+	}
+	tk.epoch = epoch
+
+	return tk.random
+}
+
+func (tk *ticketKeys) next(now time.Time) time.Duration {
+	if tk.rotation <= 0 {
+		return 0
+	}
+
+	return tk.rotation - time.Duration(now.UnixNano()%int64(tk.rotation))
+}
+
+func deriveTicketKey(master [32]byte, epoch int64) (key [32]byte) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(epoch))
+
+	mac := hmac.New(sha256.New, master[:])
+	mac.Write(buf[:])
+	copy(key[:], mac.Sum(nil))
+
+	return key
+}
+
+func loadTicketKeyFile(path string) (keys [][32]byte, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := decodeTicketKey(line)
+		if err != nil {
+			return nil, _error(path + ": " + err.Error())
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, _error(path + ": no session ticket keys found")
+	}
+
+	return keys, nil
+}
+
+func decodeTicketKey(s string) (key [32]byte, err error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		raw, err = base64.StdEncoding.DecodeString(s)
+	}
+
+	if err != nil || len(raw) != 32 {
+		return key, _error("session ticket keys must be 32 bytes, hex or base64 encoded")
+	}
+
+	copy(key[:], raw)
+	return key, nil
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestLoadTicketKeyFile(t *testing.T) {
+	path := tempFile(ticketKeyFile, t)
+
+	keys, err := loadTicketKeyFile(path)
+	if err != nil {
+		t.Fatalf("Error loading ticket key file: %s", err.Error())
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 ticket keys, got %d", len(keys))
+	}
+
+	if keys[0][0] != 0x00 || keys[0][31] != 0x1f {
+		t.Fatal("Hex ticket key was not decoded")
+	}
+
+	if _, err = loadTicketKeyFile(tempFile("deadbeef\n", t)); err == nil {
+		t.Fatal("loadTicketKeyFile did not catch a short key")
+	}
+}
+
+func TestTicketKeyRotation(t *testing.T) {
+	path := tempFile(ticketKeyFile, t)
+
+	tk1, err := newTicketKeys(path, time.Hour, 1)
+	if err != nil {
+		t.Fatalf("Error loading ticket keys: %s", err.Error())
+	}
+
+	tk2, err := newTicketKeys(path, time.Hour, 1)
+	if err != nil {
+		t.Fatalf("Error loading ticket keys: %s", err.Error())
+	}
+
+	now := time.Now()
+	keys1, keys2 := tk1.keys(now), tk2.keys(now)
+
+	if len(keys1) != 6 {
+		t.Fatalf("Expected 6 ticket keys, got %d", len(keys1))
+	}
+
+	if keys1[0] != keys2[0] {
+		t.Fatal("Nodes sharing a key file derived different ticket keys")
+	}
+
+	later := tk1.keys(now.Add(time.Hour))
+	if later[0] == keys1[0] || later[2] != keys1[0] {
+		t.Fatal("Rotation did not retain the previous ticket key")
+	}
+
+	if keys1[1] != later[0] {
+		t.Fatal("The next period's ticket key was not accepted ahead of rotation")
+	}
+
+	random, err := newTicketKeys("", time.Hour, 1)
+	if err != nil {
+		t.Fatalf("Error creating random ticket keys: %s", err.Error())
+	}
+
+	first := random.keys(now)
+	second := random.keys(now.Add(time.Hour))
+
+	if len(second) != 2 || second[1] != first[0] {
+		t.Fatal("Random rotation did not retain the previous ticket key")
+	}
+}
+
+func TestSharedTicketKeys(t *testing.T) {
+	path := tempFile(ticketKeyFile, t)
+	cache := tls.NewLRUClientSessionCache(1)
+
+	config1 := testConfig(t)
+	config1.TicketKeys = path
+	config1.TicketRotation = time.Hour
+
+	srv1 := NewServer(config1)
+	defer srv1.Stop()
+	srv1.Start()
+
+	config2 := testConfig(t)
+	config2.TicketKeys = path
+	config2.TicketRotation = time.Hour
+
+	srv2 := NewServer(config2)
+	defer srv2.Stop()
+	srv2.Start()
+
+	if resumed(config1, cache, t) {
+		t.Fatal("First connection unexpectedly resumed a session")
+	}
+
+	if !resumed(config2, cache, t) {
+		t.Fatal("Session did not resume on a node sharing ticket keys")
+	}
+}
+
+func resumed(config *Config, cache tls.ClientSessionCache, t *testing.T) bool {
+	cli := tls.Client(unixConn(config.Address, t), &tls.Config{
+		ServerName:         "example.org",
+		InsecureSkipVerify: true,
+		ClientSessionCache: cache,
+	})
+	defer cli.Close()
+
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("Error during handshake: %s", err.Error())
+	}
+
+	// read until the server closes so any session tickets are processed
+	cli.Read(make([]byte, 1))
+
+	return cli.ConnectionState().DidResume
+}
+
+var (
+	ticketKeyFile = `# session ticket keys, newest first
+000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=
+`
+)
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/benburkert/cheeseman/sni"
+)
+
+type problem struct {
+	at      Origin
+	message string
+}
+
+type adapterRef struct {
+	name string
+	at   Origin
+}
+
+var (
+	settingChecks = map[string]func(string) error{
+		"type":           sni.CheckOneOf("tcp", "tcp4", "tcp6", "unix", "unixpacket", "unixgram"),
+		"unknownsni":     sni.CheckOneOf(UnknownSNIDefault, UnknownSNIReject, UnknownSNIFallback, UnknownSNIDestination),
+		"minversion":     func(s string) error { _, err := parseVersion(s); return err },
+		"maxversion":     func(s string) error { _, err := parseVersion(s); return err },
+		"ciphersuites":   func(s string) error { _, err := parseCipherSuites(s); return err },
+		"curves":         func(s string) error { _, err := parseCurves(s); return err },
+		"sessiontickets": func(s string) error { _, err := parseBool("sessiontickets", s); return err },
+		"ticketrotation": sni.CheckDuration,
+		"ticketoverlap":  func(s string) error { _, err := strconv.Atoi(s); return err },
+		"ocsp":           func(s string) error { _, err := parseBool("ocsp", s); return err },
+		"ocspmuststaple": func(s string) error { _, err := parseBool("ocspmuststaple", s); return err },
+		"ocspresponder":  func(s string) error { _, err := url.Parse(s); return err },
+		"signertimeout":  sni.CheckDuration,
+		"sniadapter":     checkAdapter,
+		"listeners":      checkListeners,
+	}
+
+	hostKeys = []string{"backend", "minversion", "maxversion", "ciphersuites", "curves", "sessiontickets"}
+)
+
+// validate checks that every section and key in dict is known and that every
+// value parses. Problems are reported with the file and line they were read
+// from, ordered by where they appear, with interpolated values shown as the
+// references they came from.
+func validate(dict Dict, origins map[string]map[string]Origin, found secrets) error {
+	var problems []problem
+
+	report := func(at Origin, section, key, message string) {
+		name := "[" + section + "]"
+		if key != "" {
+			name += " " + key
+		}
+
+		problems = append(problems, problem{at: at, message: name + ": " + found.redact(section, key, message)})
+	}
+
+	adapters := make(map[string]bool)
+
+	if name, ok := dict["cheesed"]["sniadapter"]; ok && sni.Registered(strings.ToLower(name)) {
+		name = strings.ToLower(name)
+		at := origins["cheesed"]["sniadapter"]
+
+		for _, ref := range reachableAdapters(dict, origins, adapterRef{name: name, at: at}) {
+			adapters[ref.name] = true
+
+			if dict[ref.name] == nil {
+				report(ref.at, ref.name, "", "no section configures the "+ref.name+" adapter")
+			}
+		}
+
+		if config, err := adapterConfig(dict, name, nil); err != nil {
+			report(at, "cheesed", "sniadapter", err.Error())
+		} else {
+			for _, err := range sni.Validate(name, config) {
+				keyErr, ok := err.(sni.KeyError)
+				if !ok {
+					keyErr = sni.KeyError{Message: err.Error()}
+				}
+
+				section, key, at := locate(dict, origins, adapterRef{name: name, at: at}, keyErr.Key)
+				report(at, section, key, keyErr.Message)
+			}
+		}
+	}
+
+	for section, settings := range dict {
+		switch {
+		case section == "cheesed":
+			for key, value := range settings {
+				if !isSetting(key) {
+					report(origins[section][key], section, key, "unknown setting")
+				} else if check := settingChecks[key]; check != nil {
+					if err := check(value); err != nil {
+						report(origins[section][key], section, key, err.Error())
+					}
+				}
+			}
+
+			if len(SplitList(settings["fallback"])) == 0 {
+				if strings.EqualFold(strings.TrimSpace(settings["unknownsni"]), UnknownSNIFallback) {
+					report(origins[section]["unknownsni"], section, "unknownsni", "fallback requires a fallback backend")
+				}
+
+				for _, item := range SplitList(settings["listeners"]) {
+					if listener, err := parseListener(item); err == nil && listener.UnknownSNI == UnknownSNIFallback {
+						report(origins[section]["listeners"], section, "listeners", item+": fallback requires a fallback backend")
+					}
+				}
+			}
+
+		case strings.HasPrefix(section, "host:"):
+			for key, value := range settings {
+				if !contains(hostKeys, key) {
+					report(origins[section][key], section, key, "unknown setting")
+				} else if check := settingChecks[key]; check != nil {
+					if err := check(value); err != nil {
+						report(origins[section][key], section, key, err.Error())
+					}
+				}
+			}
+
+		case section == "destinations":
+			for ip, name := range settings {
+				if net.ParseIP(ip) == nil {
+					report(origins[section][ip], section, ip, "is not an IP address")
+				} else if strings.TrimSpace(name) == "" {
+					report(origins[section][ip], section, ip, "a host name is required")
+				}
+			}
+
+		case !adapters[section]:
+			report(origins[section][""], section, "", "unknown section, not used by the sniadapter")
+		}
+	}
+
+	return problemError(problems)
+}
+
+// problemError joins problems into one error, ordered by where they appear.
+func problemError(problems []problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].at.File != problems[j].at.File {
+			return problems[i].at.File < problems[j].at.File
+		}
+
+		if problems[i].at.Line != problems[j].at.Line {
+			return problems[i].at.Line < problems[j].at.Line
+		}
+
+		return problems[i].message < problems[j].message
+	})
+
+	messages := make([]string, len(problems))
+	for i, p := range problems {
+		messages[i] = p.at.String() + ": " + p.message
+	}
+
+	return _error(strings.Join(messages, "\n"))
+}
+
+// reachableAdapters lists ref and the adapters it composes, each with where
+// it was named.
+func reachableAdapters(dict Dict, origins map[string]map[string]Origin, ref adapterRef) []adapterRef {
+	refs := []adapterRef{ref}
+	seen := map[string]bool{ref.name: true}
+
+	for i := 0; i < len(refs); i++ {
+		for _, sub := range composedAdapters(dict, origins, refs[i].name) {
+			if !seen[sub.name] {
+				seen[sub.name] = true
+				refs = append(refs, sub)
+			}
+		}
+	}
+
+	return refs
+}
+
+func composedAdapters(dict Dict, origins map[string]map[string]Origin, name string) (refs []adapterRef) {
+	for _, key := range []string{"adapters", "adapter"} {
+		for _, sub := range SplitList(dict[name][key]) {
+			refs = append(refs, adapterRef{name: strings.ToLower(sub), at: origins[name][key]})
+		}
+	}
+
+	return refs
+}
+
+// locate finds the section and key behind a key of an adapter's config, where
+// composed adapters' keys carry an "<adapter>." prefix. Missing sections are
+// reported where the adapter was named.
+func locate(dict Dict, origins map[string]map[string]Origin, ref adapterRef, key string) (string, string, Origin) {
+	at := ref.at
+	if header, ok := origins[ref.name][""]; ok {
+		at = header
+	}
+
+	if key == "" {
+		return ref.name, "", at
+	}
+
+	if _, ok := dict[ref.name][key]; ok {
+		return ref.name, key, origins[ref.name][key]
+	}
+
+	for _, sub := range composedAdapters(dict, origins, ref.name) {
+		if key == sub.name {
+			return locate(dict, origins, sub, "")
+		}
+
+		if strings.HasPrefix(key, sub.name+".") {
+			return locate(dict, origins, sub, strings.TrimPrefix(key, sub.name+"."))
+		}
+	}
+
+	return ref.name, key, at
+}
+
+func isSetting(key string) bool {
+	for _, setting := range Settings {
+		if setting.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkListeners(s string) error {
+	for _, item := range SplitList(s) {
+		listener, err := parseListener(item)
+		if err != nil {
+			return err
+		}
+
+		if listener.UnknownSNI == "" {
+			continue
+		}
+
+		if err = sni.CheckOneOf(UnknownSNIDefault, UnknownSNIReject, UnknownSNIFallback, UnknownSNIDestination)(listener.UnknownSNI); err != nil {
+			return _error(item + ": unknownsni " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func checkAdapter(s string) error {
+	if !sni.Registered(strings.ToLower(s)) {
+		return _error(s + " is not a registered adapter")
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStrictConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cheesed.ini")
+
+	writeConfigFile(dir, "cheesed.ini", `[cheesed]
+adress         = 127.0.0.1:8443
+sniadapter     = chain
+ticketrotation = daily
+unknownsni     = fallback
+
+[chain]
+adapters = inmemory, localca
+
+[inmemroy]
+foo.example.com = /etc/foo.pem
+
+[localca]
+hosts    = *.test
+lifetime = soon
+
+[host:foo.example.com]
+backends = 10.0.0.1:80
+
+[destinations]
+foo.example.com = 10.0.0.1
+`, t)
+
+	err := NewConfig().Load(path)
+	if err == nil {
+		t.Fatal("Load did not catch an invalid config")
+	}
+
+	expected := []string{
+		path + ":2: [cheesed] adress: unknown setting",
+		path + ":4: [cheesed] ticketrotation: time: invalid duration",
+		path + ":5: [cheesed] unknownsni: fallback requires a fallback backend",
+		path + ":8: [inmemory]: no section configures the inmemory adapter",
+		path + ":10: [inmemroy]: unknown section",
+		path + ":13: [localca] ca: a value is required",
+		path + ":13: [localca] cakey: a value is required",
+		path + ":15: [localca] lifetime: time: invalid duration",
+		path + ":18: [host:foo.example.com] backends: unknown setting",
+		path + ":21: [destinations] foo.example.com: is not an IP address",
+	}
+
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected problems:\n%s", err.Error())
+	}
+
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Fatalf("Problem %d is %q, expected %q", i, lines[i], prefix)
+		}
+	}
+
+	writeConfigFile(dir, "override.ini", "[cheesed]\naddress = 127.0.0.1:8443\n", t)
+
+	err = NewConfig().LoadWith(filepath.Join(dir, "override.ini"), map[string]string{"ocsp": "maybe"})
+	if err == nil || !strings.HasPrefix(err.Error(), "flags or environment: [cheesed] ocsp:") {
+		t.Fatalf("Unexpected override error: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// The signer protocol is a single JSON request and response per connection:
+//
+//	{"key": "<id>", "hash": "SHA-256", "pss": false, "digest": "<base64>"}
+//	{"signature": "<base64>"} or {"error": "message"}
+//
+// A zero hash asks the signer to sign the message directly, as with Ed25519.
+// A request for the key's public half instead of a signature is answered
+// with the PKIX encoded key:
+//
+//	{"key": "<id>", "public": true}
+//	{"public": "<base64>"}
+type Request struct {
+	Key    string `json:"key"`
+	Hash   string `json:"hash"`
+	PSS    bool   `json:"pss"`
+	Digest []byte `json:"digest"`
+	Public bool   `json:"public,omitempty"`
+}
+
+type Response struct {
+	Signature []byte `json:"signature,omitempty"`
+	Public    []byte `json:"public,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Error struct {
+	message string
+}
+
+type Stats struct {
+	Calls    int64
+	Errors   int64
+	Timeouts int64
+}
+
+type Client struct {
+	Socket  string
+	Timeout time.Duration
+
+	calls    int64
+	errors   int64
+	timeouts int64
+}
+
+type remoteKey struct {
+	client *Client
+	id     string
+	public crypto.PublicKey
+}
+
+var (
+	DefaultTimeout = 2 * time.Second
+
+	hashes = map[crypto.Hash]string{
+		crypto.SHA1:   "SHA-1",
+		crypto.SHA256: "SHA-256",
+		crypto.SHA384: "SHA-384",
+		crypto.SHA512: "SHA-512",
+	}
+)
+
+func NewClient(socket string, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Client{Socket: socket, Timeout: timeout}
+}
+
+// Key returns a crypto.Signer for the key the signer daemon knows as id. The
+// public key is taken from the certificate the key belongs to.
+func (client *Client) Key(id string, public crypto.PublicKey) crypto.Signer {
+	return &remoteKey{client: client, id: id, public: public}
+}
+
+// Public returns the public key the signer daemon holds as id.
+func (client *Client) Public(id string) (crypto.PublicKey, error) {
+	response, err := client.roundTrip(&Request{Key: id, Public: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParsePKIXPublicKey(response.Public)
+}
+
+func (client *Client) Stats() Stats {
+	return Stats{
+		Calls:    atomic.LoadInt64(&client.calls),
+		Errors:   atomic.LoadInt64(&client.errors),
+		Timeouts: atomic.LoadInt64(&client.timeouts),
+	}
+}
+
+func (client *Client) Sign(request *Request) ([]byte, error) {
+	atomic.AddInt64(&client.calls, 1)
+
+	response, err := client.roundTrip(request)
+	if err != nil {
+		atomic.AddInt64(&client.errors, 1)
+
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			atomic.AddInt64(&client.timeouts, 1)
+		}
+
+		return nil, err
+	}
+
+	return response.Signature, nil
+}
+
+func (client *Client) roundTrip(request *Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", client.Socket, client.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(client.Timeout))
+
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, err
+	}
+
+	response := new(Response)
+	if err = json.NewDecoder(conn).Decode(response); err != nil {
+		return nil, err
+	}
+
+	if response.Error != "" {
+		return nil, newError("signer " + request.Key + ": " + response.Error)
+	}
+
+	return response, nil
+}
+
+func (key *remoteKey) Public() crypto.PublicKey {
+	return key.public
+}
+
+func (key *remoteKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	request := &Request{Key: key.id, Digest: digest}
+
+	if hash := opts.HashFunc(); hash != 0 {
+		name, ok := hashes[hash]
+		if !ok {
+			return nil, newError("Unsupported hash: " + hash.String())
+		}
+
+		request.Hash = name
+	}
+
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		request.PSS = true
+	}
+
+	return key.client.Sign(request)
+}
+
+// Serve answers signing requests on listener with keys, for use by signer
+// daemons.
+func Serve(listener net.Listener, keys map[string]crypto.Signer) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go serveConn(conn, keys)
+	}
+}
+
+func serveConn(conn net.Conn, keys map[string]crypto.Signer) {
+	defer conn.Close()
+
+	request := new(Request)
+	if err := json.NewDecoder(conn).Decode(request); err != nil {
+		return
+	}
+
+	response := new(Response)
+
+	var err error
+	if request.Public {
+		response.Public, err = public(keys, request)
+	} else {
+		response.Signature, err = sign(keys, request)
+	}
+
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+func public(keys map[string]crypto.Signer, request *Request) ([]byte, error) {
+	key, ok := keys[request.Key]
+	if !ok {
+		return nil, newError("Unknown key: " + request.Key)
+	}
+
+	return x509.MarshalPKIXPublicKey(key.Public())
+}
+
+func sign(keys map[string]crypto.Signer, request *Request) ([]byte, error) {
+	key, ok := keys[request.Key]
+	if !ok {
+		return nil, newError("Unknown key: " + request.Key)
+	}
+
+	var opts crypto.SignerOpts = crypto.Hash(0)
+
+	if request.Hash != "" {
+		hash, ok := hashByName(request.Hash)
+		if !ok {
+			return nil, newError("Unsupported hash: " + request.Hash)
+		}
+
+		opts = hash
+	}
+
+	if request.PSS {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: opts.HashFunc()}
+	}
+
+	return key.Sign(rand.Reader, request.Digest, opts)
+}
+
+func hashByName(name string) (crypto.Hash, bool) {
+	for hash, n := range hashes {
+		if n == name {
+			return hash, true
+		}
+	}
+
+	return 0, false
+}
+
+func newError(message string) error {
+	return Error{message: message}
+}
+
+func (err Error) Error() string {
+	return err.message
+}
@@ -0,0 +1,122 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestSign(t *testing.T) {
+	ecKey, _ := pki.GenerateKey(pki.KeyTypeECDSA)
+	rsaKey, _ := pki.GenerateKey(pki.KeyTypeRSA)
+
+	client := testSigner(map[string]crypto.Signer{"ec": ecKey, "rsa": rsaKey}, t)
+	digest := sha256.Sum256([]byte("hello"))
+
+	signature, err := client.Key("ec", ecKey.Public()).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Error signing with ecdsa key: %s", err.Error())
+	}
+
+	if !ecdsa.VerifyASN1(ecKey.Public().(*ecdsa.PublicKey), digest[:], signature) {
+		t.Fatal("Invalid ecdsa signature")
+	}
+
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+	signature, err = client.Key("rsa", rsaKey.Public()).Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		t.Fatalf("Error signing with rsa key: %s", err.Error())
+	}
+
+	if err = rsa.VerifyPSS(rsaKey.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], signature, opts); err != nil {
+		t.Fatalf("Invalid rsa-pss signature: %s", err.Error())
+	}
+
+	if _, err = client.Key("missing", ecKey.Public()).Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Fatal("Expected an error for an unknown key")
+	}
+
+	if stats := client.Stats(); stats.Calls != 3 || stats.Errors != 1 || stats.Timeouts != 0 {
+		t.Fatalf("Unexpected signer stats: %+v", stats)
+	}
+
+	public, err := client.Public("ec")
+	if err != nil {
+		t.Fatalf("Error fetching public key: %s", err.Error())
+	}
+
+	if !ecKey.Public().(*ecdsa.PublicKey).Equal(public) {
+		t.Fatal("The signer returned the wrong public key")
+	}
+
+	if _, err = client.Public("missing"); err == nil {
+		t.Fatal("Expected an error for the public half of an unknown key")
+	}
+}
+
+func TestSignTimeout(t *testing.T) {
+	socket := filepath.Join(tempDir(t), "signer.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := NewClient(socket, 50*time.Millisecond)
+	key, _ := pki.GenerateKey(pki.KeyTypeECDSA)
+	digest := sha256.Sum256([]byte("hello"))
+
+	if _, err = client.Key("ec", key.Public()).Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Fatal("Expected a timeout from an unresponsive signer")
+	}
+
+	if stats := client.Stats(); stats.Errors != 1 || stats.Timeouts != 1 {
+		t.Fatalf("Unexpected signer stats: %+v", stats)
+	}
+}
+
+func testSigner(keys map[string]crypto.Signer, t *testing.T) *Client {
+	socket := filepath.Join(tempDir(t), "signer.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go Serve(listener, keys)
+
+	return NewClient(socket, time.Second)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
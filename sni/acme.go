@@ -0,0 +1,157 @@
+package sni
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type ACMEAdapter struct {
+	manager *autocert.Manager
+	cache   autocert.Cache
+	hosts   map[string]bool
+}
+
+func NewACMEAdapter(config map[string]string) (Adapter, error) {
+	adapter := new(ACMEAdapter)
+	adapter.hosts = make(map[string]bool)
+
+	for _, host := range splitList(config["hosts"]) {
+		adapter.hosts[strings.ToLower(host)] = true
+	}
+
+	if len(adapter.hosts) == 0 {
+		return nil, Error{message: "acme adapter requires at least one host."}
+	}
+
+	cache := config["cache"]
+	if cache == "" {
+		return nil, Error{message: "acme adapter requires a cache directory."}
+	}
+
+	client := &acme.Client{DirectoryURL: config["directory"]}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+
+	if path, ok := config["ca"]; ok {
+		httpClient, err := caHTTPClient(path)
+		if err != nil {
+			return nil, err
+		}
+
+		client.HTTPClient = httpClient
+	}
+
+	adapter.cache = autocert.DirCache(cache)
+	adapter.manager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      adapter.cache,
+		HostPolicy: adapter.hostPolicy,
+		Client:     client,
+		Email:      config["email"],
+	}
+
+	if s, ok := config["renewbefore"]; ok {
+		renewBefore, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+
+		adapter.manager.RenewBefore = renewBefore
+	}
+
+	return adapter, nil
+}
+
+func (adp *ACMEAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if !adp.hosts[strings.ToLower(hello.ServerName)] {
+		return nil, nil
+	}
+
+	return adp.manager.GetCertificate(hello)
+}
+
+// Peek returns a valid certificate from the cache without ordering one.
+func (adp *ACMEAdapter) Peek(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	if !adp.hosts[name] || contains(hello.SupportedProtos, acme.ALPNProto) {
+		return nil, nil
+	}
+
+	for _, key := range []string{name, name + "+rsa"} {
+		data, err := adp.cache.Get(context.Background(), key)
+		if err == autocert.ErrCacheMiss {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.X509KeyPair(data, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+
+		if time.Now().Before(cert.Leaf.NotAfter) {
+			return &cert, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (adp *ACMEAdapter) NextProtos() []string {
+	return []string{acme.ALPNProto}
+}
+
+func (adp *ACMEAdapter) hostPolicy(_ context.Context, host string) error {
+	if !adp.hosts[strings.ToLower(host)] {
+		return Error{message: host + " is not an acme host."}
+	}
+
+	return nil
+}
+
+var _ = Register("acme", func(config map[string]string) (Adapter, error) {
+	return NewACMEAdapter(config)
+})
+
+var _ = RegisterValidator("acme", settings{
+	known: map[string]func(string) error{
+		"hosts":       nil,
+		"cache":       nil,
+		"directory":   checkURL,
+		"ca":          nil,
+		"email":       nil,
+		"renewbefore": CheckDuration,
+	},
+	required: []string{"hosts", "cache"},
+}.validate)
+
+func caHTTPClient(path string) (*http.Client, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, Error{message: "No certificates found in " + path}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,47 @@
+package sni
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestNewACMEAdapter(t *testing.T) {
+	cache, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+
+	_, err = NewACMEAdapter(map[string]string{"cache": cache})
+	if err == nil {
+		t.Fatal("NewACMEAdapter did not catch missing hosts")
+	}
+
+	_, err = NewACMEAdapter(map[string]string{"hosts": "foo.example.com"})
+	if err == nil {
+		t.Fatal("NewACMEAdapter did not catch a missing cache directory")
+	}
+
+	adapter, err := NewAdapter("acme", map[string]string{
+		"hosts":       "foo.example.com, Bar.example.com",
+		"cache":       cache,
+		"directory":   "https://127.0.0.1:14000/dir",
+		"renewbefore": "720h",
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating an acme adapter: %s", err.Error())
+	}
+
+	cert, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "github.com"})
+	if cert != nil || err != nil {
+		t.Fatal("The acme adapter answered for a host it does not manage")
+	}
+
+	protos := adapter.(Protocols).NextProtos()
+	if len(protos) != 1 || protos[0] != acme.ALPNProto {
+		t.Fatalf("Unexpected acme protocols: %v", protos)
+	}
+}
@@ -1,11 +1,59 @@
 package sni
 
-import "crypto/tls"
+import (
+	"crypto/tls"
+	"strings"
+)
 
 type Adapter interface {
 	Callback(*tls.ClientHelloInfo) (*tls.Certificate, error)
 }
 
+// Protocols is implemented by adapters that answer handshakes for their own
+// ALPN protocols, such as ACME TLS-ALPN-01 challenges. Connections that
+// negotiate one of these protocols are not proxied.
+type Protocols interface {
+	NextProtos() []string
+}
+
+// Reloader is implemented by adapters that can refresh their certificates
+// from their backing store on demand.
+type Reloader interface {
+	Reload() error
+}
+
+// Enumerator is implemented by adapters that can list the certificates they
+// serve, keyed by host name.
+type Enumerator interface {
+	Certificates() map[string]*tls.Certificate
+}
+
+// Mutator is implemented by adapters whose certificates can be replaced or
+// removed at runtime.
+type Mutator interface {
+	SetCertificate(name string, cert *tls.Certificate) error
+	RemoveCertificate(name string) error
+}
+
+// Peeker is implemented by adapters whose Callback has side effects, such as
+// issuing certificates. Peek returns only a certificate the adapter already
+// holds.
+type Peeker interface {
+	Peek(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// Stater is implemented by adapters that cache certificates or wrap adapters
+// that do. Stats reports false when there is no cache behind the adapter.
+type Stater interface {
+	Stats() (CacheStats, bool)
+}
+
+// Closer is implemented by adapters that hold connections or background
+// goroutines that should be released when the server stops.
+type Closer interface {
+	Close() error
+}
+
 type Error struct {
 	message string
 }
@@ -20,7 +68,18 @@ func Register(name string, initializer func(config map[string]string) (Adapter,
 	return nil
 }
 
+// NewAdapter creates the named adapter. When a state directory is set, the
+// adapter's journal of runtime changes is replayed before it is returned.
 func NewAdapter(name string, config map[string]string) (adapter Adapter, err error) {
+	adapter, err = newAdapter(name, config)
+	if err != nil || stateDir == "" {
+		return
+	}
+
+	return NewJournalAdapter(adapter, stateDir, NewKeySources(config))
+}
+
+func newAdapter(name string, config map[string]string) (adapter Adapter, err error) {
 	initializer, ok := registry[name]
 
 	if !ok {
@@ -33,3 +92,69 @@ func NewAdapter(name string, config map[string]string) (adapter Adapter, err err
 func (err Error) Error() string {
 	return err.message
 }
+
+// Peek looks hello up in adapter without side effects, using Peek for the
+// adapters that have one.
+func Peek(adapter Adapter, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if peeker, ok := adapter.(Peeker); ok {
+		return peeker.Peek(hello)
+	}
+
+	return adapter.Callback(hello)
+}
+
+// Stats returns the cache statistics of adapter, if it is a Stater with a
+// cache behind it.
+func Stats(adapter Adapter) (CacheStats, bool) {
+	if stater, ok := adapter.(Stater); ok {
+		return stater.Stats()
+	}
+
+	return CacheStats{}, false
+}
+
+// closeAdapter closes adapter if it is a Closer.
+func closeAdapter(adapter Adapter) error {
+	if closer, ok := adapter.(Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func splitList(s string) (items []string) {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// lookup finds name in table, falling back to a wildcard entry for its parent
+// domain.
+func lookup(table map[string]*tls.Certificate, name string) *tls.Certificate {
+	name = strings.ToLower(name)
+
+	if cert, ok := table[name]; ok {
+		return cert
+	}
+
+	if i := strings.Index(name, "."); i > 0 {
+		return table["*"+name[i:]]
+	}
+
+	return nil
+}
+
+func copyTable(table map[string]*tls.Certificate) map[string]*tls.Certificate {
+	certs := make(map[string]*tls.Certificate, len(table))
+	for name, cert := range table {
+		certs[name] = cert
+	}
+
+	return certs
+}
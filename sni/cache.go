@@ -0,0 +1,263 @@
+package sni
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type CacheAdapter struct {
+	adapter     Adapter
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]*cacheEntry
+	calls       map[string]*cacheCall
+	swept       time.Time
+	lock        sync.Mutex
+	stats       CacheStats
+}
+
+type CacheStats struct {
+	Hits         uint64
+	NegativeHits uint64
+	Misses       uint64
+	Coalesced    uint64
+	Errors       uint64
+}
+
+type cacheEntry struct {
+	cert    *tls.Certificate
+	expires time.Time
+}
+
+type cacheCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+var (
+	defaultCacheTTL         = 5 * time.Minute
+	defaultCacheNegativeTTL = 30 * time.Second
+)
+
+func NewCacheAdapter(config map[string]string) (Adapter, error) {
+	name := strings.ToLower(config["adapter"])
+	if name == "" {
+		return nil, Error{message: "cache adapter requires an adapter."}
+	}
+
+	adapter, err := newAdapter(name, SubConfig(config, name))
+	if err != nil {
+		return nil, Error{message: "cache adapter " + name + ": " + err.Error()}
+	}
+
+	ttl, negativeTTL := defaultCacheTTL, defaultCacheNegativeTTL
+
+	if s, ok := config["ttl"]; ok {
+		if ttl, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := config["negativettl"]; ok {
+		if negativeTTL, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewCache(adapter, ttl, negativeTTL), nil
+}
+
+func NewCache(adapter Adapter, ttl, negativeTTL time.Duration) *CacheAdapter {
+	return &CacheAdapter{
+		adapter:     adapter,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*cacheEntry),
+		calls:       make(map[string]*cacheCall),
+	}
+}
+
+func (adp *CacheAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if adp.handlesProtocol(hello.SupportedProtos) {
+		return adp.adapter.Callback(hello)
+	}
+
+	name := strings.ToLower(hello.ServerName)
+	now := time.Now()
+
+	adp.lock.Lock()
+
+	if entry, ok := adp.entries[name]; ok && now.Before(entry.expires) {
+		adp.lock.Unlock()
+
+		if entry.cert == nil {
+			atomic.AddUint64(&adp.stats.NegativeHits, 1)
+		} else {
+			atomic.AddUint64(&adp.stats.Hits, 1)
+		}
+
+		return entry.cert, nil
+	}
+
+	if call, ok := adp.calls[name]; ok {
+		adp.lock.Unlock()
+		atomic.AddUint64(&adp.stats.Coalesced, 1)
+
+		<-call.done
+		return call.cert, call.err
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	adp.calls[name] = call
+	adp.lock.Unlock()
+
+	atomic.AddUint64(&adp.stats.Misses, 1)
+	call.cert, call.err = adp.adapter.Callback(hello)
+
+	adp.lock.Lock()
+	delete(adp.calls, name)
+
+	if call.err != nil {
+		atomic.AddUint64(&adp.stats.Errors, 1)
+	} else {
+		adp.store(name, call.cert, now)
+	}
+	adp.lock.Unlock()
+
+	close(call.done)
+	return call.cert, call.err
+}
+
+func (adp *CacheAdapter) NextProtos() []string {
+	if protocols, ok := adp.adapter.(Protocols); ok {
+		return protocols.NextProtos()
+	}
+
+	return nil
+}
+
+func (adp *CacheAdapter) Stats() (CacheStats, bool) {
+	return CacheStats{
+		Hits:         atomic.LoadUint64(&adp.stats.Hits),
+		NegativeHits: atomic.LoadUint64(&adp.stats.NegativeHits),
+		Misses:       atomic.LoadUint64(&adp.stats.Misses),
+		Coalesced:    atomic.LoadUint64(&adp.stats.Coalesced),
+		Errors:       atomic.LoadUint64(&adp.stats.Errors),
+	}, true
+}
+
+func (stats *CacheStats) add(other CacheStats) {
+	stats.Hits += other.Hits
+	stats.NegativeHits += other.NegativeHits
+	stats.Misses += other.Misses
+	stats.Coalesced += other.Coalesced
+	stats.Errors += other.Errors
+}
+
+func (adp *CacheAdapter) Reload() error {
+	if reloader, ok := adp.adapter.(Reloader); ok {
+		if err := reloader.Reload(); err != nil {
+			return err
+		}
+	}
+
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	adp.entries = make(map[string]*cacheEntry)
+	return nil
+}
+
+func (adp *CacheAdapter) Peek(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return Peek(adp.adapter, hello)
+}
+
+func (adp *CacheAdapter) Close() error {
+	return closeAdapter(adp.adapter)
+}
+
+func (adp *CacheAdapter) Purge(name string) {
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	delete(adp.entries, strings.ToLower(name))
+}
+
+func (adp *CacheAdapter) Certificates() map[string]*tls.Certificate {
+	if enumerator, ok := adp.adapter.(Enumerator); ok {
+		return enumerator.Certificates()
+	}
+
+	return nil
+}
+
+func (adp *CacheAdapter) SetCertificate(name string, cert *tls.Certificate) error {
+	mutator, ok := adp.adapter.(Mutator)
+	if !ok {
+		return Error{message: "cache adapter wraps an adapter that cannot be changed."}
+	}
+
+	defer adp.Purge(name)
+	return mutator.SetCertificate(name, cert)
+}
+
+func (adp *CacheAdapter) RemoveCertificate(name string) error {
+	mutator, ok := adp.adapter.(Mutator)
+	if !ok {
+		return Error{message: "cache adapter wraps an adapter that cannot be changed."}
+	}
+
+	defer adp.Purge(name)
+	return mutator.RemoveCertificate(name)
+}
+
+func (adp *CacheAdapter) store(name string, cert *tls.Certificate, now time.Time) {
+	ttl := adp.ttl
+	if cert == nil {
+		ttl = adp.negativeTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	if now.Sub(adp.swept) > adp.ttl {
+		for key, entry := range adp.entries {
+			if !now.Before(entry.expires) {
+				delete(adp.entries, key)
+			}
+		}
+
+		adp.swept = now
+	}
+
+	adp.entries[name] = &cacheEntry{cert: cert, expires: now.Add(ttl)}
+}
+
+func (adp *CacheAdapter) handlesProtocol(offered []string) bool {
+	for _, proto := range adp.NextProtos() {
+		if contains(offered, proto) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ = Register("cache", func(config map[string]string) (Adapter, error) {
+	return NewCacheAdapter(config)
+})
+
+var _ = RegisterValidator("cache", settings{
+	known: map[string]func(string) error{
+		"adapter":     nil,
+		"ttl":         CheckDuration,
+		"negativettl": CheckDuration,
+	},
+	required: []string{"adapter"},
+	composes: []string{"adapter"},
+}.validate)
@@ -0,0 +1,135 @@
+package sni
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingAdapter struct {
+	calls int64
+	delay time.Duration
+	cert  *tls.Certificate
+}
+
+func (adp *countingAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	atomic.AddInt64(&adp.calls, 1)
+	time.Sleep(adp.delay)
+
+	if strings.ToLower(hello.ServerName) == "foo.example.com" {
+		return adp.cert, nil
+	}
+
+	return nil, nil
+}
+
+func TestCacheAdapter(t *testing.T) {
+	upstream := &countingAdapter{cert: new(tls.Certificate)}
+	cache := NewCache(upstream, time.Hour, 50*time.Millisecond)
+
+	foo := &tls.ClientHelloInfo{ServerName: "Foo.example.com"}
+	bar := &tls.ClientHelloInfo{ServerName: "bar.example.com"}
+
+	cache.Callback(foo)
+	cert, _ := cache.Callback(foo)
+
+	if cert != upstream.cert {
+		t.Fatal("Cached certificate was not returned")
+	}
+
+	cache.Callback(bar)
+	cache.Callback(bar)
+
+	if upstream.calls != 2 {
+		t.Fatalf("Expected 2 upstream lookups, got %d", upstream.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cache.Callback(bar)
+	cache.Callback(foo)
+
+	if upstream.calls != 3 {
+		t.Fatalf("Negative entry did not expire before the positive entry, %d lookups", upstream.calls)
+	}
+
+	stats, _ := cache.Stats()
+	if stats.Hits != 2 || stats.NegativeHits != 1 || stats.Misses != 3 {
+		t.Fatalf("Unexpected cache stats: %+v", stats)
+	}
+}
+
+func TestCacheAdapterCoalescing(t *testing.T) {
+	upstream := &countingAdapter{cert: new(tls.Certificate), delay: 50 * time.Millisecond}
+	cache := NewCache(upstream, time.Hour, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if cert, _ := cache.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert == nil {
+				t.Error("Coalesced lookup did not return the certificate")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if upstream.calls != 1 {
+		t.Fatalf("Concurrent lookups were not coalesced, %d upstream calls", upstream.calls)
+	}
+
+	if stats, _ := cache.Stats(); stats.Coalesced+stats.Hits != 9 {
+		t.Fatalf("Unexpected cache stats: %+v", stats)
+	}
+}
+
+func TestNewCacheAdapter(t *testing.T) {
+	certFile, keyFile := testPair(t)
+
+	adapter, err := NewAdapter("cache", map[string]string{
+		"adapter":                  "inmemory",
+		"ttl":                      "1m",
+		"negativettl":              "5s",
+		"inmemory.foo.example.com": certFile + "," + keyFile,
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating a cache adapter: %s", err.Error())
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert == nil {
+		t.Fatal("The cache adapter did not consult the wrapped adapter")
+	}
+
+	if _, err = NewAdapter("cache", map[string]string{}); err == nil {
+		t.Fatal("NewCacheAdapter did not catch a missing adapter")
+	}
+}
+
+func TestCacheStatsForwarded(t *testing.T) {
+	upstream := &countingAdapter{cert: new(tls.Certificate)}
+	cache := NewCache(upstream, time.Hour, time.Hour)
+	memory := &InMemoryAdapter{table: make(map[string]*tls.Certificate)}
+
+	cache.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"})
+	cache.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"})
+
+	journal, err := NewJournalAdapter(&ChainAdapter{adapters: []Adapter{memory, cache}}, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Error creating a journal: %s", err.Error())
+	}
+
+	if stats, ok := Stats(journal); !ok || stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("Unexpected forwarded cache stats: %+v, %v", stats, ok)
+	}
+
+	if _, ok := Stats(&ChainAdapter{adapters: []Adapter{memory}}); ok {
+		t.Fatal("A chain without a cache reported cache stats")
+	}
+}
@@ -0,0 +1,210 @@
+package sni
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+type ChainAdapter struct {
+	names    []string
+	adapters []Adapter
+}
+
+func NewChainAdapter(config map[string]string) (Adapter, error) {
+	adapter := new(ChainAdapter)
+
+	for _, name := range splitList(config["adapters"]) {
+		name = strings.ToLower(name)
+
+		sub, err := newAdapter(name, SubConfig(config, name))
+		if err != nil {
+			return nil, Error{message: "chain adapter " + name + ": " + err.Error()}
+		}
+
+		adapter.names = append(adapter.names, name)
+		adapter.adapters = append(adapter.adapters, sub)
+	}
+
+	if len(adapter.adapters) == 0 {
+		return nil, Error{message: "chain adapter requires at least one adapter."}
+	}
+
+	return adapter, nil
+}
+
+func (adp *ChainAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return adp.lookup(hello, Adapter.Callback)
+}
+
+func (adp *ChainAdapter) Peek(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return adp.lookup(hello, Peek)
+}
+
+func (adp *ChainAdapter) lookup(hello *tls.ClientHelloInfo, callback func(Adapter, *tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Certificate, error) {
+	adapters := adp.adapters
+
+	if handlers := adp.protocolAdapters(hello.SupportedProtos); len(handlers) > 0 {
+		adapters = handlers
+	}
+
+	var firstErr error
+
+	for _, adapter := range adapters {
+		cert, err := callback(adapter, hello)
+
+		if cert != nil {
+			return cert, nil
+		}
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, firstErr
+}
+
+func (adp *ChainAdapter) Close() (err error) {
+	for _, adapter := range adp.adapters {
+		if closeErr := closeAdapter(adapter); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Stats adds up the statistics of the caches in the chain.
+func (adp *ChainAdapter) Stats() (stats CacheStats, found bool) {
+	for _, adapter := range adp.adapters {
+		if cacheStats, ok := Stats(adapter); ok {
+			stats.add(cacheStats)
+			found = true
+		}
+	}
+
+	return stats, found
+}
+
+func (adp *ChainAdapter) NextProtos() (protos []string) {
+	for _, adapter := range adp.adapters {
+		if protocols, ok := adapter.(Protocols); ok {
+			protos = append(protos, protocols.NextProtos()...)
+		}
+	}
+
+	return protos
+}
+
+func (adp *ChainAdapter) Reload() error {
+	for i, adapter := range adp.adapters {
+		if reloader, ok := adapter.(Reloader); ok {
+			if err := reloader.Reload(); err != nil {
+				return Error{message: "chain adapter " + adp.names[i] + ": " + err.Error()}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Certificates lists the certificates of every adapter that can enumerate
+// them. Earlier adapters win, as they do for lookups.
+func (adp *ChainAdapter) Certificates() map[string]*tls.Certificate {
+	certs := make(map[string]*tls.Certificate)
+
+	for i := len(adp.adapters) - 1; i >= 0; i-- {
+		if enumerator, ok := adp.adapters[i].(Enumerator); ok {
+			for name, cert := range enumerator.Certificates() {
+				certs[name] = cert
+			}
+		}
+	}
+
+	return certs
+}
+
+// SetCertificate and RemoveCertificate go to the first adapter in the chain
+// that can be changed at runtime.
+func (adp *ChainAdapter) SetCertificate(name string, cert *tls.Certificate) error {
+	mutator, err := adp.mutator()
+	if err != nil {
+		return err
+	}
+
+	return mutator.SetCertificate(name, cert)
+}
+
+func (adp *ChainAdapter) RemoveCertificate(name string) error {
+	mutator, err := adp.mutator()
+	if err != nil {
+		return err
+	}
+
+	return mutator.RemoveCertificate(name)
+}
+
+func (adp *ChainAdapter) mutator() (Mutator, error) {
+	for _, adapter := range adp.adapters {
+		if mutator, ok := adapter.(Mutator); ok {
+			return mutator, nil
+		}
+	}
+
+	return nil, Error{message: "chain adapter has no adapter that can be changed."}
+}
+
+func (adp *ChainAdapter) protocolAdapters(offered []string) (adapters []Adapter) {
+	for _, adapter := range adp.adapters {
+		protocols, ok := adapter.(Protocols)
+		if !ok {
+			continue
+		}
+
+		for _, proto := range protocols.NextProtos() {
+			if contains(offered, proto) {
+				adapters = append(adapters, adapter)
+				break
+			}
+		}
+	}
+
+	return adapters
+}
+
+var _ = Register("chain", func(config map[string]string) (Adapter, error) {
+	return NewChainAdapter(config)
+})
+
+var _ = RegisterValidator("chain", settings{
+	known:    map[string]func(string) error{"adapters": nil},
+	required: []string{"adapters"},
+	composes: []string{"adapters"},
+}.validate)
+
+// SubConfig returns the settings a composite adapter's config carries for
+// the named adapter, with the "<name>." key prefix removed.
+func SubConfig(config map[string]string, name string) map[string]string {
+	prefix := name + "."
+	sub := make(map[string]string)
+
+	for key, value := range config {
+		if strings.HasPrefix(key, prefix) {
+			sub[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+
+	inheritKeySettings(config, sub)
+
+	return sub
+}
+
+func contains(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+
+	return false
+}
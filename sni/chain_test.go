@@ -0,0 +1,56 @@
+package sni
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestChainAdapter(t *testing.T) {
+	certFile, keyFile := testPair(t)
+	caFile, caKeyFile, _ := testCA(t)
+
+	config := map[string]string{
+		"adapters":                 "InMemory, localca",
+		"inmemory.foo.example.com": certFile + "," + keyFile,
+		"localca.ca":               caFile,
+		"localca.cakey":            caKeyFile,
+		"localca.hosts":            "*.test",
+	}
+
+	adapter, err := NewAdapter("chain", config)
+	if err != nil {
+		t.Fatalf("Error creating a chain adapter: %s", err.Error())
+	}
+
+	static, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"})
+	if err != nil || static == nil {
+		t.Fatal("The chain adapter did not consult the first adapter")
+	}
+
+	minted, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "bar.test"})
+	if err != nil || minted == nil {
+		t.Fatal("The chain adapter did not fall through to the second adapter")
+	}
+
+	missing, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "github.com"})
+	if err != nil || missing != nil {
+		t.Fatal("The chain adapter answered for a host no adapter knows")
+	}
+
+	if _, err = NewAdapter("chain", map[string]string{"adapters": "inmemory, bogus"}); err == nil {
+		t.Fatal("NewChainAdapter did not catch an unknown adapter")
+	}
+}
+
+func TestSubConfig(t *testing.T) {
+	sub := SubConfig(map[string]string{
+		"adapters":                   "inmemory",
+		"inmemory.foo.example.com":   "/path/*.pem",
+		"localca.hosts":              "*.test",
+		"chain.inmemory.bar.example": "/other/*.pem",
+	}, "inmemory")
+
+	if len(sub) != 1 || sub["foo.example.com"] != "/path/*.pem" {
+		t.Fatalf("Unexpected sub config: %v", sub)
+	}
+}
@@ -0,0 +1,327 @@
+package sni
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecAdapter delegates lookups to a pool of helper processes. Each request
+// is a single line of JSON written to a helper's stdin:
+//
+//	{"servername": "foo.example.com"}
+//
+// and the helper answers with a single line on stdout, either a certificate,
+// a miss or an error:
+//
+//	{"chain": "<PEM certificates>", "key": "<PEM private key>"}
+//	{"miss": true}
+//	{"error": "message"}
+//
+// Up to processes copies of the helper run at once, each answering one
+// request at a time. Anything a helper writes to stderr is logged.
+type ExecAdapter struct {
+	command []string
+	timeout time.Duration
+	keys    KeySources
+	helpers chan *execHelper
+}
+
+type execHelper struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+type execRequest struct {
+	ServerName string `json:"servername"`
+}
+
+type execResponse struct {
+	Chain string `json:"chain"`
+	Key   string `json:"key"`
+	Miss  bool   `json:"miss"`
+	Error string `json:"error"`
+}
+
+var (
+	defaultExecTimeout   = 5 * time.Second
+	defaultExecProcesses = 4
+)
+
+func NewExecAdapter(config map[string]string) (Adapter, error) {
+	command, err := splitCommand(config["command"])
+	if err != nil {
+		return nil, Error{message: "exec adapter command: " + err.Error()}
+	}
+
+	if len(command) == 0 {
+		return nil, Error{message: "exec adapter requires a command."}
+	}
+
+	adapter := &ExecAdapter{
+		command: command,
+		timeout: defaultExecTimeout,
+		keys:    NewKeySources(config),
+	}
+
+	if s, ok := config["timeout"]; ok {
+		if adapter.timeout, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	processes := defaultExecProcesses
+	if s, ok := config["processes"]; ok {
+		if processes, err = strconv.Atoi(s); err != nil || processes < 1 {
+			return nil, Error{message: "Invalid processes " + s + "."}
+		}
+	}
+
+	adapter.helpers = make(chan *execHelper, processes)
+
+	first := new(execHelper)
+	if err := adapter.start(first); err != nil {
+		return nil, err
+	}
+
+	adapter.helpers <- first
+	for i := 1; i < processes; i++ {
+		adapter.helpers <- new(execHelper)
+	}
+
+	return adapter, nil
+}
+
+func (adp *ExecAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	if name == "" {
+		return nil, nil
+	}
+
+	helper := <-adp.helpers
+	defer func() { adp.helpers <- helper }()
+
+	if helper.cmd == nil {
+		if err := adp.start(helper); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := adp.roundTrip(helper, execRequest{ServerName: name})
+	if err != nil {
+		helper.stop()
+		return nil, Error{message: "exec adapter " + name + ": " + err.Error()}
+	}
+
+	if response.Error != "" {
+		return nil, Error{message: "exec adapter " + name + ": " + response.Error}
+	}
+
+	if response.Miss {
+		return nil, nil
+	}
+
+	cert, err := adp.keys.X509KeyPair([]byte(response.Chain), []byte(response.Key))
+	if err != nil {
+		return nil, Error{message: "exec adapter " + name + ": " + err.Error()}
+	}
+
+	return &cert, nil
+}
+
+// Reload restarts every helper once it has finished its current request.
+func (adp *ExecAdapter) Reload() (err error) {
+	helpers := make([]*execHelper, cap(adp.helpers))
+	for i := range helpers {
+		helpers[i] = <-adp.helpers
+		helpers[i].stop()
+	}
+
+	err = adp.start(helpers[0])
+
+	for _, helper := range helpers {
+		adp.helpers <- helper
+	}
+
+	return err
+}
+
+func (adp *ExecAdapter) Close() error {
+	helpers := make([]*execHelper, cap(adp.helpers))
+	for i := range helpers {
+		helpers[i] = <-adp.helpers
+		helpers[i].stop()
+	}
+
+	for _, helper := range helpers {
+		adp.helpers <- helper
+	}
+
+	return nil
+}
+
+func (adp *ExecAdapter) roundTrip(helper *execHelper, request execRequest) (*execResponse, error) {
+	line, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = helper.stdin.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		line, err := helper.stdout.ReadBytes('\n')
+		done <- result{line, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+
+		response := new(execResponse)
+		if err = json.Unmarshal(res.line, response); err != nil {
+			return nil, err
+		}
+
+		return response, nil
+	case <-time.After(adp.timeout):
+		return nil, Error{message: "helper timed out"}
+	}
+}
+
+func (adp *ExecAdapter) start(helper *execHelper) error {
+	cmd := exec.Command(adp.command[0], adp.command[1:]...)
+	cmd.Stderr = &lineLogger{prefix: "exec adapter " + filepath.Base(adp.command[0])}
+	cmd.WaitDelay = time.Second
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	helper.cmd = cmd
+	helper.stdin = stdin
+	helper.stdout = bufio.NewReader(stdout)
+
+	return nil
+}
+
+func (helper *execHelper) stop() {
+	if helper.cmd == nil {
+		return
+	}
+
+	helper.stdin.Close()
+	helper.cmd.Process.Kill()
+	helper.cmd.Wait()
+
+	helper.cmd = nil
+}
+
+// splitCommand splits s into arguments the way a shell would, honouring
+// single quotes, double quotes and backslash escapes. Nothing is expanded.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				arg.WriteRune('\\')
+			}
+
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, Error{message: "unterminated " + string(quote) + " quote"}
+	}
+
+	if escaped {
+		return nil, Error{message: "trailing backslash"}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+func checkCommand(s string) error {
+	_, err := splitCommand(s)
+	return err
+}
+
+var _ = Register("exec", func(config map[string]string) (Adapter, error) {
+	return NewExecAdapter(config)
+})
+
+var _ = RegisterValidator("exec", settings{
+	known: map[string]func(string) error{
+		"command":   checkCommand,
+		"timeout":   CheckDuration,
+		"processes": checkCount,
+	},
+	required: []string{"command"},
+}.validate)
@@ -0,0 +1,158 @@
+package sni
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestExecAdapter(t *testing.T) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	adapter, err := NewAdapter("exec", map[string]string{
+		"command": os.Args[0] + " -test.run=TestHelperProcess --",
+		"timeout": "500ms",
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating an exec adapter: %s", err.Error())
+	}
+
+	cert, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "Foo.example.com"})
+	if err != nil {
+		t.Fatalf("Error during lookup: %s", err.Error())
+	}
+
+	if cert == nil {
+		t.Fatal("Expected a certificate for foo.example.com")
+	}
+
+	if cert, err = adapter.Callback(&tls.ClientHelloInfo{ServerName: "missing.example.com"}); cert != nil || err != nil {
+		t.Fatalf("Expected a miss, got: %v, %v", cert, err)
+	}
+
+	if _, err = adapter.Callback(&tls.ClientHelloInfo{ServerName: "error.example.com"}); err == nil {
+		t.Fatal("Expected the helper error to be returned")
+	}
+
+	if _, err = adapter.Callback(&tls.ClientHelloInfo{ServerName: "crash.example.com"}); err == nil {
+		t.Fatal("Expected an error when the helper exits")
+	}
+
+	if cert, err = adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert == nil || err != nil {
+		t.Fatalf("Helper was not restarted: %v", err)
+	}
+}
+
+func TestExecAdapterConfig(t *testing.T) {
+	if _, err := NewAdapter("exec", map[string]string{}); err == nil {
+		t.Fatal("Expected an error without a command")
+	}
+
+	if _, err := NewAdapter("exec", map[string]string{"command": "helper 'unterminated"}); err == nil {
+		t.Fatal("Expected an error for an unterminated quote")
+	}
+}
+
+func TestExecAdapterConcurrency(t *testing.T) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	adapter, err := NewAdapter("exec", map[string]string{
+		"command":   os.Args[0] + " -test.run=TestHelperProcess --",
+		"timeout":   "5s",
+		"processes": "2",
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating an exec adapter: %s", err.Error())
+	}
+	defer adapter.(Closer).Close()
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "slow.example.com"})
+		slow <- err
+	}()
+
+	start := time.Now()
+	if cert, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert == nil || err != nil {
+		t.Fatalf("Error during lookup: %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("A lookup waited on another helper's request")
+	}
+
+	if err := <-slow; err != nil {
+		t.Fatalf("Error during slow lookup: %s", err.Error())
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := map[string][]string{
+		"helper --flag":                     {"helper", "--flag"},
+		`sh -c 'echo a; exec helper'`:       {"sh", "-c", "echo a; exec helper"},
+		`"/opt/my helper/bin" "a \"b\" \c"`: {"/opt/my helper/bin", `a "b" \c`},
+		`one\ arg ''`:                       {"one arg", ""},
+	}
+
+	for command, expected := range tests {
+		args, err := splitCommand(command)
+		if err != nil {
+			t.Fatalf("Error splitting %s: %s", command, err.Error())
+		}
+
+		if strings.Join(args, "|") != strings.Join(expected, "|") || len(args) != len(expected) {
+			t.Fatalf("Expected %q for %s, got %q", expected, command, args)
+		}
+	}
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	cert, key, err := pki.GenerateCert([]string{"foo.example.com"}, pki.KeyTypeECDSA, time.Hour, nil, nil)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+	chain := string(pki.EncodeCertificate(cert))
+
+	scanner := bufio.NewScanner(os.Stdin)
+	encoder := json.NewEncoder(os.Stdout)
+
+	for scanner.Scan() {
+		var request execRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			encoder.Encode(execResponse{Error: err.Error()})
+			continue
+		}
+
+		switch request.ServerName {
+		case "foo.example.com":
+			encoder.Encode(execResponse{Chain: chain, Key: string(keyPEM)})
+		case "slow.example.com":
+			fmt.Fprintln(os.Stderr, "slow lookup")
+			time.Sleep(2 * time.Second)
+			encoder.Encode(execResponse{Miss: true})
+		case "error.example.com":
+			encoder.Encode(execResponse{Error: "lookup failed"})
+		case "crash.example.com":
+			os.Exit(2)
+		default:
+			encoder.Encode(execResponse{Miss: true})
+		}
+	}
+
+	os.Exit(0)
+}
@@ -0,0 +1,156 @@
+package sni
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type HTTPAdapter struct {
+	url           string
+	authorization string
+	keys          KeySources
+	client        *http.Client
+}
+
+type httpCertificate struct {
+	Chain string `json:"chain"`
+	Key   string `json:"key"`
+}
+
+var (
+	defaultHTTPTimeout = 5 * time.Second
+	maxHTTPBody        = int64(1 << 20)
+)
+
+func NewHTTPAdapter(config map[string]string) (Adapter, error) {
+	adapter := &HTTPAdapter{
+		url:           config["url"],
+		authorization: config["authorization"],
+		keys:          NewKeySources(config),
+		client:        &http.Client{},
+	}
+
+	if adapter.url == "" {
+		return nil, Error{message: "http adapter requires a url."}
+	}
+
+	if !strings.Contains(adapter.url, "{servername}") {
+		adapter.url = strings.TrimSuffix(adapter.url, "/") + "/{servername}"
+	}
+
+	if _, err := url.Parse(strings.Replace(adapter.url, "{servername}", "example.com", -1)); err != nil {
+		return nil, err
+	}
+
+	if path, ok := config["ca"]; ok {
+		client, err := caHTTPClient(path)
+		if err != nil {
+			return nil, err
+		}
+
+		adapter.client = client
+	}
+
+	adapter.client.Timeout = defaultHTTPTimeout
+
+	var err error
+
+	if s, ok := config["timeout"]; ok {
+		if adapter.client.Timeout, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	ttl, negativeTTL := defaultCacheTTL, defaultCacheNegativeTTL
+
+	if s, ok := config["ttl"]; ok {
+		if ttl, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := config["negativettl"]; ok {
+		if negativeTTL, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewCache(adapter, ttl, negativeTTL), nil
+}
+
+func (adp *HTTPAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	if !validHostname(name) {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", strings.Replace(adp.url, "{servername}", url.PathEscape(name), -1), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json, application/x-pem-file")
+	if adp.authorization != "" {
+		req.Header.Set("Authorization", adp.authorization)
+	}
+
+	resp, err := adp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, Error{message: "http adapter " + name + ": " + resp.Status}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxHTTPBody))
+	if err != nil {
+		return nil, err
+	}
+
+	chain, key := body, body
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var payload httpCertificate
+
+		if err = json.Unmarshal(body, &payload); err != nil {
+			return nil, Error{message: "http adapter " + name + ": " + err.Error()}
+		}
+
+		chain, key = []byte(payload.Chain), []byte(payload.Key)
+	}
+
+	cert, err := adp.keys.X509KeyPair(chain, key)
+	if err != nil {
+		return nil, Error{message: "http adapter " + name + ": " + err.Error()}
+	}
+
+	return &cert, nil
+}
+
+var _ = Register("http", func(config map[string]string) (Adapter, error) {
+	return NewHTTPAdapter(config)
+})
+
+var _ = RegisterValidator("http", settings{
+	known: map[string]func(string) error{
+		"url":           nil,
+		"authorization": nil,
+		"ca":            nil,
+		"timeout":       CheckDuration,
+		"ttl":           CheckDuration,
+		"negativettl":   CheckDuration,
+	},
+	required: []string{"url"},
+}.validate)
@@ -0,0 +1,87 @@
+package sni
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestHTTPAdapter(t *testing.T) {
+	cert, key, err := pki.GenerateCert([]string{"foo.example.com"}, pki.KeyTypeECDSA, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+	certPEM := pki.EncodeCertificate(cert)
+
+	var requests int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/certs/foo.example.com":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(httpCertificate{Chain: string(certPEM), Key: string(keyPEM)})
+		case "/certs/pem.example.com":
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			w.Write(append(certPEM, keyPEM...))
+		case "/certs/slow.example.com":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	adapter, err := NewAdapter("http", map[string]string{
+		"url":           srv.URL + "/certs/{servername}",
+		"authorization": "Bearer secret",
+		"timeout":       "100ms",
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating an http adapter: %s", err.Error())
+	}
+
+	for _, name := range []string{"foo.example.com", "PEM.example.com"} {
+		if found, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: name}); found == nil {
+			t.Fatalf("No certificate was found for %s: %v", name, err)
+		}
+	}
+
+	if found, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "bar.example.com"}); found != nil || err != nil {
+		t.Fatal("A missing certificate was not reported as a miss")
+	}
+
+	if _, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "slow.example.com"}); err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Fatalf("A slow certificate service did not time out: %v", err)
+	}
+
+	before := atomic.LoadInt64(&requests)
+	adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"})
+	adapter.Callback(&tls.ClientHelloInfo{ServerName: "bar.example.com"})
+
+	if atomic.LoadInt64(&requests) != before {
+		t.Fatal("Certificate service responses were not cached")
+	}
+
+	unauthorized, _ := NewAdapter("http", map[string]string{"url": srv.URL + "/certs"})
+
+	if _, err := unauthorized.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); err == nil {
+		t.Fatal("An unauthorized response was not reported as an error")
+	}
+}
@@ -7,18 +7,25 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type InMemoryAdapter struct {
 	table map[string]*tls.Certificate
+	lock  sync.RWMutex
 }
 
 func NewInMemoryAdapter(config map[string]string) (Adapter, error) {
 	adapter := new(InMemoryAdapter)
 	adapter.table = make(map[string]*tls.Certificate)
+	keys := NewKeySources(config)
 
 	for servername, glob := range config {
-		config, err := loadCertificate(glob)
+		if isKeySetting(servername) {
+			continue
+		}
+
+		config, err := loadCertificate(glob, keys)
 
 		if err != nil {
 			return nil, err
@@ -31,31 +38,93 @@ func NewInMemoryAdapter(config map[string]string) (Adapter, error) {
 }
 
 func (adp *InMemoryAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	cert, ok := adp.table[strings.ToLower(hello.ServerName)]
-	if !ok {
-		return nil, nil
-	}
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	return lookup(adp.table, hello.ServerName), nil
+}
+
+func (adp *InMemoryAdapter) Certificates() map[string]*tls.Certificate {
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	return copyTable(adp.table)
+}
+
+func (adp *InMemoryAdapter) SetCertificate(name string, cert *tls.Certificate) error {
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
 
-	return cert, nil
+	adp.table[strings.ToLower(name)] = cert
+	return nil
+}
+
+func (adp *InMemoryAdapter) RemoveCertificate(name string) error {
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	delete(adp.table, strings.ToLower(name))
+	return nil
 }
 
 var _ = Register("inmemory", func(config map[string]string) (Adapter, error) {
 	return NewInMemoryAdapter(config)
 })
 
-func loadCertificate(globs string) (*tls.Certificate, error) {
+var _ = RegisterValidator("inmemory", func(config map[string]string) (errs []error) {
+	for name, globs := range config {
+		if isKeySetting(name) {
+			if err := checkKeySetting(name, globs); err != nil {
+				errs = append(errs, KeyError{Key: name, Message: err.Error()})
+			}
+
+			continue
+		}
+
+		if !validHostname(strings.TrimPrefix(strings.ToLower(name), "*.")) {
+			errs = append(errs, KeyError{Key: name, Message: "is not a host name"})
+		} else if len(splitList(globs)) == 0 {
+			errs = append(errs, KeyError{Key: name, Message: "a value is required"})
+		}
+	}
+
+	return errs
+})
+
+func loadCertificate(globs string, keys KeySources) (*tls.Certificate, error) {
 	var cbytes, kbytes *[]byte
+	var keyRef []byte
+	var source, bundle string
 
 	parts := strings.Split(globs, ",")
 
 	for _, glob := range parts {
+		if strings.HasPrefix(glob, "passphrase=") {
+			source = strings.TrimPrefix(glob, "passphrase=")
+			continue
+		}
+
+		if _, _, ok := keys.reference(glob); ok {
+			keyRef = []byte(glob)
+			continue
+		}
+
 		paths, err := filepath.Glob(glob)
 
 		if err != nil {
 			return nil, err
 		}
 
+		if len(paths) == 0 {
+			return nil, errors.New("No files match " + glob)
+		}
+
 		for _, path := range paths {
+			if isBundle(path) {
+				bundle = path
+				continue
+			}
+
 			block, bytes, err := decode(path)
 
 			if err != nil {
@@ -65,7 +134,7 @@ func loadCertificate(globs string) (*tls.Certificate, error) {
 			switch block.Type {
 			case "CERTIFICATE":
 				cbytes = bytes
-			case "RSA PRIVATE KEY":
+			case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
 				kbytes = bytes
 			default:
 				return nil, errors.New("Unknown file type " + block.Type + ": " + path)
@@ -73,14 +142,27 @@ func loadCertificate(globs string) (*tls.Certificate, error) {
 		}
 	}
 
+	if bundle != "" {
+		return loadBundle(bundle, source)
+	}
+
 	if cbytes == nil {
 		return nil, errors.New("Certificate file not found.")
 	}
+	if keyRef != nil {
+		kbytes = &keyRef
+	}
 	if kbytes == nil {
-		return nil, errors.New("RSA Key file not found.")
+		return nil, errors.New("Key file not found.")
 	}
 
-	cert, err := tls.X509KeyPair(*cbytes, *kbytes)
+	key, err := decryptKey(*kbytes, source)
+	if err != nil {
+		return nil, err
+	}
+	kbytes = &key
+
+	cert, err := keys.X509KeyPair(*cbytes, *kbytes)
 	return &cert, err
 }
 
@@ -106,6 +188,9 @@ func decode(filepath string) (*pem.Block, *[]byte, error) {
 	}
 
 	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, nil, errors.New("No PEM data found: " + filepath)
+	}
 
 	return block, &buf, nil
 }
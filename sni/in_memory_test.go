@@ -1,8 +1,15 @@
 package sni
 
 import (
+	"crypto"
+	"crypto/tls"
 	"io/ioutil"
+	"net"
+	"path/filepath"
 	"testing"
+
+	"github.com/benburkert/cheeseman/pki"
+	"github.com/benburkert/cheeseman/signer"
 )
 
 func TestNewInMemoryAdapter(t *testing.T) {
@@ -20,19 +27,82 @@ func TestNewInMemoryAdapter(t *testing.T) {
 		t.Fatalf("Error creating an in memory adapter: %s", err.Error())
 	}
 
-	nilConfig := adapter.Callback("github.com")
+	nilConfig, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "github.com"})
 
 	if nilConfig != nil {
 		t.Fatal("An invalid config was returned by the InMemoryAdapter")
 	}
 
-	fooConfig := adapter.Callback("foo.example.com")
+	fooConfig, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"})
 
 	if fooConfig == nil {
 		t.Fatal("No tls config was found for foo.example.com")
 	}
 }
 
+func TestInMemoryWildcard(t *testing.T) {
+	certFile, keyFile := testPair(t)
+
+	adapter, err := NewInMemoryAdapter(map[string]string{
+		"*.example.com": certFile + "," + keyFile,
+	})
+	if err != nil {
+		t.Fatalf("Error creating an in memory adapter: %s", err.Error())
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "Foo.Example.com"}); cert == nil {
+		t.Fatal("No certificate was found for foo.example.com")
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.bar.example.com"}); cert != nil {
+		t.Fatal("The wildcard matched more than one label")
+	}
+}
+
+func TestInMemorySigner(t *testing.T) {
+	certFile, keyFile := testPair(t)
+
+	keyPEM, _ := ioutil.ReadFile(keyFile)
+	pair, _ := tls.X509KeyPair([]byte(certExampleOrg), keyPEM)
+	other, _ := pki.GenerateKey(pki.KeyTypeECDSA)
+
+	socket := filepath.Join(t.TempDir(), "signer.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error creating signer socket: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go signer.Serve(listener, map[string]crypto.Signer{
+		"foo":   pair.PrivateKey.(crypto.Signer),
+		"other": other,
+	})
+
+	adapter, err := NewInMemoryAdapter(map[string]string{
+		"signer":          socket,
+		"foo.example.com": certFile + ",signer:foo",
+	})
+	if err != nil {
+		t.Fatalf("Error creating an in memory adapter with a signer: %s", err.Error())
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert == nil {
+		t.Fatal("No certificate was found for foo.example.com")
+	}
+
+	if _, err = NewInMemoryAdapter(map[string]string{
+		"signer":          socket,
+		"foo.example.com": certFile + ",signer:other",
+	}); err == nil {
+		t.Fatal("A remote key that does not match its certificate was accepted")
+	}
+
+	if _, err = NewInMemoryAdapter(map[string]string{"foo.example.com": certFile + ",signer:foo"}); err == nil {
+		t.Fatal("A signer reference was resolved without a configured signer")
+	}
+}
+
 func testPair(t *testing.T) (string, string) {
 	certFile, err := ioutil.TempFile("", "cert.pem")
 	if err != nil {
@@ -0,0 +1,436 @@
+package sni
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalAdapter records runtime certificate changes in a state directory
+// and replays them when it is created, so they survive restarts.
+//
+// Each entry records the static certificate the change replaced. On replay a
+// change only applies while the static config still has that certificate;
+// once the config has been edited for the host, the static certificate wins
+// and the entry is dropped.
+//
+// Entries refer to keys rather than hold them: a key reference such as
+// "signer:web-1", or a file in the keys directory next to the journal.
+type JournalAdapter struct {
+	adapter   Adapter
+	keys      KeySources
+	path      string
+	keyDir    string
+	static    map[string]string
+	entries   map[string]*journalEntry
+	conflicts []string
+	compacted bool
+	lock      sync.Mutex
+}
+
+type journalEntry struct {
+	Op       string    `json:"op"`
+	Name     string    `json:"name"`
+	Chain    string    `json:"chain,omitempty"`
+	Key      string    `json:"key,omitempty"`
+	Replaces string    `json:"replaces,omitempty"`
+	Time     time.Time `json:"time"`
+
+	cert *tls.Certificate
+}
+
+const (
+	journalSet    = "set"
+	journalRemove = "remove"
+)
+
+var (
+	stateDir = ""
+)
+
+// SetStateDir sets the directory NewAdapter keeps its journal in.
+func SetStateDir(dir string) {
+	stateDir = dir
+}
+
+func NewJournalAdapter(adapter Adapter, dir string, keys KeySources) (*JournalAdapter, error) {
+	mutator, ok := adapter.(Mutator)
+	if !ok {
+		return nil, Error{message: "State directory requires an adapter that can be changed at runtime."}
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	adp := &JournalAdapter{
+		adapter: adapter,
+		keys:    keys,
+		path:    filepath.Join(dir, "journal"),
+		keyDir:  filepath.Join(dir, "keys"),
+		static:  make(map[string]string),
+		entries: make(map[string]*journalEntry),
+	}
+
+	if enumerator, ok := adapter.(Enumerator); ok {
+		for name, cert := range enumerator.Certificates() {
+			adp.static[name] = fingerprint(cert)
+		}
+	}
+
+	entries, err := readJournal(adp.path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Replaces != adp.static[entry.Name] {
+			if !contains(adp.conflicts, entry.Name) {
+				adp.conflicts = append(adp.conflicts, entry.Name)
+			}
+
+			continue
+		}
+
+		adp.entries[entry.Name] = entry
+	}
+
+	for name, entry := range adp.entries {
+		if entry.cert, err = entry.apply(mutator, adp.keys); err != nil {
+			return nil, Error{message: "journal " + name + ": " + err.Error()}
+		}
+
+		if entry.Op == journalRemove && entry.Replaces == "" {
+			delete(adp.entries, name)
+		}
+	}
+
+	return adp, nil
+}
+
+func (adp *JournalAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return adp.adapter.Callback(hello)
+}
+
+// Conflicts lists the hosts whose journaled changes were dropped in favor of
+// the static config.
+func (adp *JournalAdapter) Conflicts() []string {
+	return adp.conflicts
+}
+
+func (adp *JournalAdapter) SetCertificate(name string, cert *tls.Certificate) error {
+	name = strings.ToLower(name)
+
+	return adp.record(&journalEntry{Op: journalSet, Name: name, Chain: encodeChain(cert)}, cert)
+}
+
+func (adp *JournalAdapter) RemoveCertificate(name string) error {
+	return adp.record(&journalEntry{Op: journalRemove, Name: strings.ToLower(name)}, nil)
+}
+
+func (adp *JournalAdapter) Certificates() map[string]*tls.Certificate {
+	if enumerator, ok := adp.adapter.(Enumerator); ok {
+		return enumerator.Certificates()
+	}
+
+	return nil
+}
+
+func (adp *JournalAdapter) Peek(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return Peek(adp.adapter, hello)
+}
+
+func (adp *JournalAdapter) Stats() (CacheStats, bool) {
+	return Stats(adp.adapter)
+}
+
+func (adp *JournalAdapter) Close() error {
+	return closeAdapter(adp.adapter)
+}
+
+func (adp *JournalAdapter) NextProtos() []string {
+	if protocols, ok := adp.adapter.(Protocols); ok {
+		return protocols.NextProtos()
+	}
+
+	return nil
+}
+
+func (adp *JournalAdapter) Reload() error {
+	reloader, ok := adp.adapter.(Reloader)
+	if !ok {
+		return nil
+	}
+
+	if err := reloader.Reload(); err != nil {
+		return err
+	}
+
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	for _, entry := range adp.entries {
+		if _, err := entry.apply(adp.adapter.(Mutator), adp.keys); err != nil {
+			return Error{message: "journal " + entry.Name + ": " + err.Error()}
+		}
+	}
+
+	return nil
+}
+
+func (adp *JournalAdapter) record(entry *journalEntry, cert *tls.Certificate) error {
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	// opening a journal only reads it, it is compacted before the first
+	// change is appended
+	if !adp.compacted {
+		if err := adp.compact(); err != nil {
+			return err
+		}
+
+		adp.compacted = true
+	}
+
+	if cert != nil {
+		key, err := adp.keyReference(cert)
+		if err != nil {
+			return err
+		}
+
+		entry.Key = key
+	}
+
+	entry.Replaces = adp.static[entry.Name]
+	entry.Time = time.Now().UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(adp.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		return err
+	}
+
+	mutator := adp.adapter.(Mutator)
+	if entry.Op == journalSet {
+		err = mutator.SetCertificate(entry.Name, cert)
+	} else {
+		err = mutator.RemoveCertificate(entry.Name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	adp.entries[entry.Name] = entry
+	return nil
+}
+
+// compact rewrites the journal with only the entries in effect.
+func (adp *JournalAdapter) compact() error {
+	names := make([]string, 0, len(adp.entries))
+	for name := range adp.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := adp.entries[name]
+
+		// entries written before keys were stored by reference hold the key
+		if entry.cert != nil && isPEM(entry.Key) {
+			key, err := adp.keyReference(entry.cert)
+			if err != nil {
+				return err
+			}
+
+			entry.Key = key
+		}
+	}
+
+	tmp := adp.path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, name := range names {
+		if err = encoder.Encode(adp.entries[name]); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, adp.path); err != nil {
+		return err
+	}
+
+	return adp.pruneKeys()
+}
+
+// keyReference returns what the journal records for cert's key: the key
+// reference it was resolved from, or the path of a private file the key is
+// stored in. The key material itself is never journaled.
+func (adp *JournalAdapter) keyReference(cert *tls.Certificate) (string, error) {
+	if key, ok := cert.PrivateKey.(*referencedKey); ok {
+		return key.ref, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return "", Error{message: "Private key cannot be journaled: " + err.Error()}
+	}
+
+	if err = os.MkdirAll(adp.keyDir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(adp.keyDir, fingerprint(cert)+".key")
+	tmp := path + ".tmp"
+
+	if err = ioutil.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(tmp, path)
+}
+
+// pruneKeys removes stored keys no entry in effect refers to.
+func (adp *JournalAdapter) pruneKeys() error {
+	paths, err := filepath.Glob(filepath.Join(adp.keyDir, "*.key"))
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool, len(adp.entries))
+	for _, entry := range adp.entries {
+		used[entry.Key] = true
+	}
+
+	for _, path := range paths {
+		if !used[path] {
+			os.Remove(path)
+		}
+	}
+
+	return nil
+}
+
+func (entry *journalEntry) apply(mutator Mutator, keys KeySources) (*tls.Certificate, error) {
+	if entry.Op == journalRemove {
+		return nil, mutator.RemoveCertificate(entry.Name)
+	}
+
+	key := []byte(entry.Key)
+
+	if _, _, ok := keys.reference(entry.Key); !ok && !isPEM(entry.Key) {
+		var err error
+
+		if key, err = ioutil.ReadFile(entry.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := keys.X509KeyPair([]byte(entry.Chain), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, mutator.SetCertificate(entry.Name, &cert)
+}
+
+func readJournal(path string) ([]*journalEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*journalEntry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		entry := new(journalEntry)
+
+		if err = json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// a torn final write is expected after a crash, anything
+			// before it is corruption
+			if scanner.Scan() {
+				return nil, Error{message: fmt.Sprintf("Corrupt journal entry on line %d of %s: %s", line, path, err.Error())}
+			}
+
+			break
+		}
+
+		switch entry.Op {
+		case journalSet, journalRemove:
+		default:
+			return nil, Error{message: "Unknown journal operation " + entry.Op + "."}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func encodeChain(cert *tls.Certificate) string {
+	var chain []byte
+	for _, der := range cert.Certificate {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	return string(chain)
+}
+
+func isPEM(key string) bool {
+	return strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN")
+}
+
+func fingerprint(cert *tls.Certificate) string {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
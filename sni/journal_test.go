@@ -0,0 +1,137 @@
+package sni
+
+import (
+	"crypto"
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestJournalAdapter(t *testing.T) {
+	dir := t.TempDir()
+
+	SetStateDir(dir)
+	defer SetStateDir("")
+
+	static := map[string]string{"foo.example.com": journalPair("foo.example.com", t)}
+
+	adapter, err := NewAdapter("inmemory", static)
+	if err != nil {
+		t.Fatalf("Error creating a journaled adapter: %s", err.Error())
+	}
+
+	mutator := adapter.(Mutator)
+
+	bar := journalCert("bar.example.com", t)
+	foo := journalCert("foo.example.com", t)
+
+	if err = mutator.SetCertificate("bar.example.com", bar); err != nil {
+		t.Fatalf("Error setting certificate: %s", err.Error())
+	}
+
+	if err = mutator.SetCertificate("foo.example.com", foo); err != nil {
+		t.Fatalf("Error replacing certificate: %s", err.Error())
+	}
+
+	mutator.SetCertificate("baz.example.com", journalCert("baz.example.com", t))
+	mutator.RemoveCertificate("baz.example.com")
+
+	adapter, err = NewAdapter("inmemory", static)
+	if err != nil {
+		t.Fatalf("Error replaying the journal: %s", err.Error())
+	}
+
+	assertServes(adapter, "bar.example.com", bar, t)
+	assertServes(adapter, "foo.example.com", foo, t)
+	assertServes(adapter, "baz.example.com", nil, t)
+
+	static["foo.example.com"] = journalPair("foo.example.com", t)
+
+	adapter, err = NewAdapter("inmemory", static)
+	if err != nil {
+		t.Fatalf("Error replaying the journal: %s", err.Error())
+	}
+
+	assertServes(adapter, "bar.example.com", bar, t)
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); fingerprint(cert) == fingerprint(foo) {
+		t.Fatal("Runtime certificate overrode an edited static config")
+	}
+
+	if conflicts := adapter.(*JournalAdapter).Conflicts(); len(conflicts) != 1 || conflicts[0] != "foo.example.com" {
+		t.Fatalf("Unexpected conflicts: %v", conflicts)
+	}
+
+	remote := journalCert("remote.example.com", t)
+	remote.PrivateKey = &referencedKey{Signer: remote.PrivateKey.(crypto.Signer), ref: "signer:remote"}
+
+	if err = adapter.(Mutator).SetCertificate("remote.example.com", remote); err != nil {
+		t.Fatalf("Error setting a certificate with a remote key: %s", err.Error())
+	}
+
+	journal, _ := ioutil.ReadFile(filepath.Join(dir, "journal"))
+
+	if strings.Contains(string(journal), "PRIVATE KEY") {
+		t.Fatal("The journal holds private key material")
+	}
+
+	if !strings.Contains(string(journal), `"key":"signer:remote"`) {
+		t.Fatal("The journal did not record the key reference")
+	}
+
+	if keys, _ := filepath.Glob(filepath.Join(dir, "keys", "*.key")); len(keys) != 1 {
+		t.Fatalf("Expected the one local runtime key to be stored, found %d", len(keys))
+	}
+}
+
+func TestJournalCorruption(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal")
+
+	valid := `{"op":"remove","name":"foo.example.com","time":"2020-01-01T00:00:00Z"}`
+
+	ioutil.WriteFile(path, []byte(valid+"\n"+`{"op":"rem`), 0600)
+
+	if _, err := NewJournalAdapter(&InMemoryAdapter{table: make(map[string]*tls.Certificate)}, dir, nil); err != nil {
+		t.Fatalf("A torn final entry was not tolerated: %s", err.Error())
+	}
+
+	ioutil.WriteFile(path, []byte(`{"op":"rem`+"\n"+valid+"\n"), 0600)
+
+	if _, err := NewJournalAdapter(&InMemoryAdapter{table: make(map[string]*tls.Certificate)}, dir, nil); err == nil {
+		t.Fatal("A corrupt entry before the end of the journal was ignored")
+	}
+}
+
+func assertServes(adapter Adapter, name string, expected *tls.Certificate, t *testing.T) {
+	cert, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		t.Fatalf("Error looking up %s: %s", name, err.Error())
+	}
+
+	if fingerprint(cert) != fingerprint(expected) {
+		t.Fatalf("Unexpected certificate for %s", name)
+	}
+}
+
+func journalCert(hostname string, t *testing.T) *tls.Certificate {
+	cert, key, err := pki.GenerateCert([]string{hostname}, pki.KeyTypeECDSA, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+func journalPair(hostname string, t *testing.T) string {
+	cert := journalCert(hostname, t)
+
+	keyPEM, _ := pki.EncodeKey(cert.PrivateKey.(crypto.Signer))
+
+	return tempFile(string(pki.EncodeCertificate(cert.Leaf)), t) + "," + tempFile(string(keyPEM), t)
+}
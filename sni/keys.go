@@ -0,0 +1,241 @@
+package sni
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benburkert/cheeseman/signer"
+	"github.com/youmark/pkcs8"
+)
+
+// KeySource resolves a key reference such as "signer:web-1" into a signer for
+// the certificate's public key, so private keys can live outside the process.
+type KeySource func(id string, public crypto.PublicKey) (crypto.Signer, error)
+
+// KeySources maps key reference schemes to their sources.
+type KeySources map[string]KeySource
+
+// referencedKey is a key resolved from a key reference. It remembers the
+// reference so the key can be recorded without its material.
+type referencedKey struct {
+	crypto.Signer
+	ref string
+}
+
+var (
+	signers     = make(map[string]*signer.Client)
+	signersLock sync.Mutex
+)
+
+// NewKeySources returns the key sources an adapter config enables. The
+// "signer" setting names a signer daemon's socket, which resolves
+// "signer:<id>" references, and "signertimeout" bounds its requests.
+func NewKeySources(config map[string]string) KeySources {
+	sources := make(KeySources)
+
+	if socket := config["signer"]; socket != "" {
+		timeout, _ := time.ParseDuration(config["signertimeout"])
+		client := SignerClient(socket, timeout)
+
+		sources["signer"] = func(id string, _ crypto.PublicKey) (crypto.Signer, error) {
+			public, err := client.Public(id)
+			if err != nil {
+				return nil, err
+			}
+
+			return client.Key(id, public), nil
+		}
+	}
+
+	return sources
+}
+
+// SignerClient returns the client for the signer daemon at socket. Clients
+// are shared so their stats cover every adapter that uses the daemon.
+func SignerClient(socket string, timeout time.Duration) *signer.Client {
+	signersLock.Lock()
+	defer signersLock.Unlock()
+
+	id := socket + " " + timeout.String()
+
+	client, ok := signers[id]
+	if !ok {
+		client = signer.NewClient(socket, timeout)
+		signers[id] = client
+	}
+
+	return client
+}
+
+func isKeySetting(key string) bool {
+	return key == "signer" || key == "signertimeout"
+}
+
+// inheritKeySettings copies the key settings of config into sub, unless sub
+// has its own.
+func inheritKeySettings(config, sub map[string]string) {
+	if _, ok := sub["signer"]; ok {
+		return
+	}
+
+	for _, key := range []string{"signer", "signertimeout"} {
+		if value, ok := config[key]; ok {
+			sub[key] = value
+		}
+	}
+}
+
+func (sources KeySources) reference(ref string) (KeySource, string, bool) {
+	ref = strings.TrimSpace(ref)
+
+	i := strings.Index(ref, ":")
+	if i <= 0 {
+		return nil, "", false
+	}
+
+	source, ok := sources[ref[:i]]
+	if !ok {
+		return nil, "", false
+	}
+
+	return source, ref[i+1:], true
+}
+
+// X509KeyPair is tls.X509KeyPair, except key may also be a key reference.
+func (sources KeySources) X509KeyPair(chain, key []byte) (tls.Certificate, error) {
+	if source, id, ok := sources.reference(string(key)); ok {
+		return referencedKeyPair(chain, source, strings.TrimSpace(string(key)), id)
+	}
+
+	return tls.X509KeyPair(chain, key)
+}
+
+// LoadX509KeyPair is tls.LoadX509KeyPair, except keyFile may also be a key
+// reference or an encrypted key, and certFile may be a PKCS#12 bundle.
+func (sources KeySources) LoadX509KeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	if isBundle(certFile) {
+		cert, err := loadBundle(certFile, "")
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+
+		return *cert, nil
+	}
+
+	chain, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if source, id, ok := sources.reference(keyFile); ok {
+		return referencedKeyPair(chain, source, strings.TrimSpace(keyFile), id)
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if key, err = decryptKey(key, ""); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(chain, key)
+}
+
+// X509KeyPair is KeySources.X509KeyPair without any key sources.
+func X509KeyPair(chain, key []byte) (tls.Certificate, error) {
+	return KeySources(nil).X509KeyPair(chain, key)
+}
+
+// LoadX509KeyPair is KeySources.LoadX509KeyPair without any key sources.
+func LoadX509KeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	return KeySources(nil).LoadX509KeyPair(certFile, keyFile)
+}
+
+func referencedKeyPair(chain []byte, source KeySource, ref, id string) (cert tls.Certificate, err error) {
+	for {
+		var block *pem.Block
+
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+
+	if len(cert.Certificate) == 0 {
+		return cert, Error{message: "No certificates found for key " + id + "."}
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return
+	}
+
+	key, err := source(id, cert.Leaf.PublicKey)
+	if err != nil {
+		return
+	}
+
+	if public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(cert.Leaf.PublicKey) {
+		return cert, Error{message: "Key " + id + " does not match its certificate."}
+	}
+
+	cert.PrivateKey = &referencedKey{Signer: key, ref: ref}
+	return
+}
+
+// decryptKey returns keyPEM with its private key decrypted using the
+// passphrase from source. Unencrypted keys are returned unchanged.
+func decryptKey(keyPEM []byte, source string) ([]byte, error) {
+	for rest := keyPEM; ; {
+		var block *pem.Block
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return keyPEM, nil
+		}
+
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			password, err := passphrase(source)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, password)
+			if err != nil {
+				return nil, Error{message: "Error decrypting private key: " + err.Error()}
+			}
+
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+		}
+
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") && x509.IsEncryptedPEMBlock(block) {
+			password, err := passphrase(source)
+			if err != nil {
+				return nil, err
+			}
+
+			der, err := x509.DecryptPEMBlock(block, password)
+			if err != nil {
+				return nil, Error{message: "Error decrypting private key: " + err.Error()}
+			}
+
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package sni
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+type LocalCAAdapter struct {
+	ca       *x509.Certificate
+	caKey    crypto.Signer
+	patterns []string
+	cacheDir string
+	keyType  string
+	lifetime time.Duration
+	maxCerts int
+	table    map[string]*localCAEntry
+	minting  map[string]*localCACall
+	lock     sync.Mutex
+}
+
+type localCAEntry struct {
+	cert *tls.Certificate
+	used time.Time
+}
+
+type localCACall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+var (
+	localCALifetime = 90 * 24 * time.Hour
+	localCAMaxCerts = 1024
+)
+
+func NewLocalCAAdapter(config map[string]string) (Adapter, error) {
+	adapter := new(LocalCAAdapter)
+	adapter.table = make(map[string]*localCAEntry)
+	adapter.minting = make(map[string]*localCACall)
+	adapter.cacheDir = config["cache"]
+	adapter.keyType = strings.ToLower(config["keytype"])
+	adapter.lifetime = localCALifetime
+	adapter.maxCerts = localCAMaxCerts
+
+	for _, pattern := range splitList(config["hosts"]) {
+		pattern = strings.ToLower(pattern)
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, Error{message: "Invalid host pattern " + pattern + ": " + err.Error()}
+		}
+
+		adapter.patterns = append(adapter.patterns, pattern)
+	}
+
+	if len(adapter.patterns) == 0 {
+		return nil, Error{message: "localca adapter requires at least one host pattern."}
+	}
+
+	switch adapter.keyType {
+	case "", pki.KeyTypeECDSA, pki.KeyTypeRSA:
+	default:
+		return nil, Error{message: "Unknown key type " + adapter.keyType + "."}
+	}
+
+	if s, ok := config["lifetime"]; ok {
+		lifetime, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+
+		adapter.lifetime = lifetime
+	}
+
+	if s, ok := config["maxcerts"]; ok {
+		maxCerts, err := strconv.Atoi(s)
+		if err != nil || maxCerts < 1 {
+			return nil, Error{message: "Invalid maxcerts " + s + "."}
+		}
+
+		adapter.maxCerts = maxCerts
+	}
+
+	var err error
+	adapter.ca, adapter.caKey, err = pki.LoadPair(config["ca"], config["cakey"])
+	if err != nil {
+		return nil, err
+	}
+
+	if !adapter.ca.IsCA {
+		return nil, Error{message: config["ca"] + " is not a CA certificate."}
+	}
+
+	return adapter, nil
+}
+
+func (adp *LocalCAAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+
+	if !validHostname(name) || !adp.allowed(name) {
+		return nil, nil
+	}
+
+	adp.lock.Lock()
+
+	if entry, ok := adp.table[name]; ok && fresh(entry.cert) {
+		entry.used = time.Now()
+		adp.lock.Unlock()
+
+		return entry.cert, nil
+	}
+
+	if call, ok := adp.minting[name]; ok {
+		adp.lock.Unlock()
+
+		<-call.done
+		return call.cert, call.err
+	}
+
+	call := &localCACall{done: make(chan struct{})}
+	adp.minting[name] = call
+	adp.lock.Unlock()
+
+	call.cert, call.err = adp.load(name)
+	if call.err != nil || !fresh(call.cert) {
+		call.cert, call.err = adp.mint(name)
+	}
+
+	adp.lock.Lock()
+	delete(adp.minting, name)
+
+	if call.err == nil {
+		adp.remember(name, call.cert)
+	}
+	adp.lock.Unlock()
+
+	close(call.done)
+	return call.cert, call.err
+}
+
+// Peek returns a fresh certificate from memory or the cache directory
+// without minting one.
+func (adp *LocalCAAdapter) Peek(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+
+	if !validHostname(name) || !adp.allowed(name) {
+		return nil, nil
+	}
+
+	adp.lock.Lock()
+	entry, ok := adp.table[name]
+	adp.lock.Unlock()
+
+	if ok && fresh(entry.cert) {
+		return entry.cert, nil
+	}
+
+	if cert, err := adp.read(name); err == nil && fresh(cert) {
+		return cert, nil
+	}
+
+	return nil, nil
+}
+
+// remember adds cert to the table, evicting the least recently used entry
+// when the table is full. The caller holds the lock.
+func (adp *LocalCAAdapter) remember(name string, cert *tls.Certificate) {
+	adp.table[name] = &localCAEntry{cert: cert, used: time.Now()}
+
+	if len(adp.table) <= adp.maxCerts {
+		return
+	}
+
+	var oldest string
+	for candidate, entry := range adp.table {
+		if oldest == "" || entry.used.Before(adp.table[oldest].used) {
+			oldest = candidate
+		}
+	}
+
+	delete(adp.table, oldest)
+}
+
+func (adp *LocalCAAdapter) allowed(name string) bool {
+	for _, pattern := range adp.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (adp *LocalCAAdapter) mint(name string) (*tls.Certificate, error) {
+	leaf, key, err := pki.GenerateCert([]string{name}, adp.keyType, adp.lifetime, adp.ca, adp.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, adp.ca.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+
+	if adp.cacheDir != "" {
+		if err := adp.store(name, leaf, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return cert, nil
+}
+
+func (adp *LocalCAAdapter) load(name string) (*tls.Certificate, error) {
+	cert, err := adp.read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	os.Chtimes(filepath.Join(adp.cacheDir, name+".crt"), now, now)
+
+	return cert, nil
+}
+
+// read loads the cached certificate for name if the current CA issued it.
+func (adp *LocalCAAdapter) read(name string) (*tls.Certificate, error) {
+	if adp.cacheDir == "" {
+		return nil, Error{message: "No cache directory."}
+	}
+
+	base := filepath.Join(adp.cacheDir, name)
+
+	cert, err := tls.LoadX509KeyPair(base+".crt", base+".key")
+	if err != nil {
+		return nil, err
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cert.Leaf.CheckSignatureFrom(adp.ca); err != nil {
+		return nil, Error{message: "Cached certificate for " + name + " was not issued by the current CA."}
+	}
+
+	return &cert, nil
+}
+
+func (adp *LocalCAAdapter) store(name string, leaf *x509.Certificate, key crypto.Signer) error {
+	if err := os.MkdirAll(adp.cacheDir, 0700); err != nil {
+		return err
+	}
+
+	keyPEM, err := pki.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM := append(pki.EncodeCertificate(leaf), pki.EncodeCertificate(adp.ca)...)
+	base := filepath.Join(adp.cacheDir, name)
+
+	if err := ioutil.WriteFile(base+".key", keyPEM, 0600); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(base+".crt", certPEM, 0644); err != nil {
+		return err
+	}
+
+	return adp.prune(base + ".crt")
+}
+
+// prune removes the least recently used certificates, other than keep, from
+// the cache directory once it holds more than maxCerts.
+func (adp *LocalCAAdapter) prune(keep string) error {
+	paths, err := filepath.Glob(filepath.Join(adp.cacheDir, "*.crt"))
+	if err != nil || len(paths) <= adp.maxCerts {
+		return err
+	}
+
+	used := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			used[path] = info.ModTime()
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return used[paths[i]].Before(used[paths[j]])
+	})
+
+	excess := len(paths) - adp.maxCerts
+
+	for _, path := range paths {
+		if excess == 0 {
+			break
+		}
+
+		if path == keep {
+			continue
+		}
+
+		os.Remove(path)
+		os.Remove(strings.TrimSuffix(path, ".crt") + ".key")
+		excess--
+	}
+
+	return nil
+}
+
+var _ = Register("localca", func(config map[string]string) (Adapter, error) {
+	return NewLocalCAAdapter(config)
+})
+
+var _ = RegisterValidator("localca", settings{
+	known: map[string]func(string) error{
+		"hosts":    nil,
+		"ca":       nil,
+		"cakey":    nil,
+		"cache":    nil,
+		"keytype":  CheckOneOf(pki.KeyTypeECDSA, pki.KeyTypeRSA),
+		"lifetime": CheckDuration,
+		"maxcerts": checkCount,
+	},
+	required: []string{"hosts", "ca", "cakey"},
+}.validate)
+
+func fresh(cert *tls.Certificate) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return false
+		}
+	}
+
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return time.Now().Add(lifetime / 3).Before(leaf.NotAfter)
+}
+
+func validHostname(name string) bool {
+	if name == "" || len(name) > 253 || strings.Contains(name, "..") {
+		return false
+	}
+
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,145 @@
+package sni
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestLocalCAAdapter(t *testing.T) {
+	caFile, caKeyFile, ca := testCA(t)
+
+	cache, err := ioutil.TempDir("", "localca")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+
+	config := map[string]string{
+		"ca":      caFile,
+		"cakey":   caKeyFile,
+		"hosts":   "*.test, localhost",
+		"cache":   cache,
+		"keytype": "ecdsa",
+	}
+
+	adapter, err := NewAdapter("localca", config)
+	if err != nil {
+		t.Fatalf("Error creating a localca adapter: %s", err.Error())
+	}
+
+	cert, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "github.com"})
+	if cert != nil || err != nil {
+		t.Fatal("The localca adapter minted a certificate for a disallowed host")
+	}
+
+	if cert, _ = adapter.(Peeker).Peek(&tls.ClientHelloInfo{ServerName: "app.test"}); cert != nil {
+		t.Fatal("The localca adapter minted a certificate on Peek")
+	}
+
+	cert, err = adapter.Callback(&tls.ClientHelloInfo{ServerName: "App.Test"})
+	if err != nil || cert == nil {
+		t.Fatalf("The localca adapter did not mint a certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	if _, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "app.test", Roots: pool}); err != nil {
+		t.Fatalf("Minted certificate did not verify: %s", err.Error())
+	}
+
+	again, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "app.test"})
+	if again != cert {
+		t.Fatal("Minted certificate was not cached in memory")
+	}
+
+	if _, err = tls.LoadX509KeyPair(filepath.Join(cache, "app.test.crt"), filepath.Join(cache, "app.test.key")); err != nil {
+		t.Fatalf("Minted certificate was not cached on disk: %s", err.Error())
+	}
+
+	restarted, _ := NewAdapter("localca", config)
+	cached, _ := restarted.(Peeker).Peek(&tls.ClientHelloInfo{ServerName: "app.test"})
+
+	if cached == nil || string(cached.Certificate[0]) != string(cert.Certificate[0]) {
+		t.Fatal("Minted certificate was not loaded from disk")
+	}
+
+	if cert, _ = adapter.Callback(&tls.ClientHelloInfo{ServerName: "../x.test"}); cert != nil {
+		t.Fatal("The localca adapter minted a certificate for an invalid host")
+	}
+}
+
+func TestLocalCARotation(t *testing.T) {
+	caFile, caKeyFile, _ := testCA(t)
+	cache := t.TempDir()
+
+	config := map[string]string{"ca": caFile, "cakey": caKeyFile, "hosts": "*.test", "cache": cache, "maxcerts": "1"}
+
+	adapter, err := NewAdapter("localca", config)
+	if err != nil {
+		t.Fatalf("Error creating a localca adapter: %s", err.Error())
+	}
+
+	first, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "a.test"})
+	adapter.Callback(&tls.ClientHelloInfo{ServerName: "b.test"})
+
+	if cached := adapter.(*LocalCAAdapter).table; len(cached) != 1 || cached["b.test"] == nil {
+		t.Fatal("The localca adapter kept more certificates in memory than maxcerts")
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(cache, "*.crt")); len(files) != 1 {
+		t.Fatalf("The localca adapter kept %d certificates on disk with maxcerts 1", len(files))
+	}
+
+	again, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "a.test"})
+	if again == first {
+		t.Fatal("An evicted certificate was served from memory")
+	}
+
+	var rotated *x509.Certificate
+	config["ca"], config["cakey"], rotated = testCA(t)
+
+	adapter, err = NewAdapter("localca", config)
+	if err != nil {
+		t.Fatalf("Error creating a localca adapter: %s", err.Error())
+	}
+
+	cert, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "a.test"})
+	if err != nil {
+		t.Fatalf("Error minting after CA rotation: %s", err.Error())
+	}
+
+	if err = cert.Leaf.CheckSignatureFrom(rotated); err != nil {
+		t.Fatal("A certificate from the previous CA was served after rotation")
+	}
+}
+
+func testCA(t *testing.T) (string, string, *x509.Certificate) {
+	ca, key, err := pki.GenerateCA("ca.test", pki.KeyTypeECDSA, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+
+	return tempFile(string(pki.EncodeCertificate(ca)), t), tempFile(string(keyPEM), t), ca
+}
+
+func tempFile(body string, t *testing.T) string {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %s", err.Error())
+	}
+	defer file.Close()
+
+	if _, err = file.WriteString(body); err != nil {
+		t.Fatalf("Error writing temp file: %s", err.Error())
+	}
+
+	return file.Name()
+}
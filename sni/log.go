@@ -0,0 +1,39 @@
+package sni
+
+import (
+	"bytes"
+	"log"
+	"os"
+)
+
+var (
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// SetLogger sets where adapters report problems they recover from, such as
+// skipped rows and failed background reloads.
+func SetLogger(l *log.Logger) {
+	logger = l
+}
+
+// lineLogger logs each line written to it, such as a helper's stderr.
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *lineLogger) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		logger.Printf("%s: %s", w.prefix, w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,88 @@
+package sni
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	defaultPassphrase string
+)
+
+// SetPassphrase sets the passphrase source used for encrypted keys that have
+// no passphrase of their own.
+func SetPassphrase(source string) {
+	defaultPassphrase = source
+}
+
+// Passphrase resolves a passphrase source: "env:NAME" reads an environment
+// variable, "file:/path" the first line of a file and "exec:command" the
+// first line a command prints.
+func Passphrase(source string) ([]byte, error) {
+	source = strings.TrimSpace(source)
+
+	i := strings.Index(source, ":")
+	if i < 0 {
+		return nil, Error{message: "Invalid passphrase source " + source + "."}
+	}
+
+	scheme, value := source[:i], source[i+1:]
+
+	switch scheme {
+	case "env":
+		passphrase, ok := os.LookupEnv(value)
+		if !ok {
+			return nil, Error{message: "Passphrase variable " + value + " is not set."}
+		}
+
+		return []byte(passphrase), nil
+	case "file":
+		data, err := ioutil.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return firstLine(data), nil
+	case "exec":
+		args, err := splitCommand(value)
+		if err != nil {
+			return nil, Error{message: "Passphrase command: " + err.Error()}
+		}
+
+		if len(args) == 0 {
+			return nil, Error{message: "Passphrase command is empty."}
+		}
+
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			return nil, Error{message: "Passphrase command " + args[0] + ": " + err.Error()}
+		}
+
+		return firstLine(out), nil
+	}
+
+	return nil, Error{message: "Unknown passphrase source " + scheme + "."}
+}
+
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+
+	return bytes.TrimRight(data, "\r")
+}
+
+func passphrase(source string) ([]byte, error) {
+	if source == "" {
+		source = defaultPassphrase
+	}
+
+	if source == "" {
+		return nil, Error{message: "Encrypted key requires a passphrase."}
+	}
+
+	return Passphrase(source)
+}
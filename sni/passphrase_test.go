@@ -0,0 +1,87 @@
+package sni
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+	"github.com/youmark/pkcs8"
+)
+
+func TestPassphrase(t *testing.T) {
+	t.Setenv("CHEESED_TEST_PASSPHRASE", "from-env")
+
+	sources := map[string]string{
+		"env:CHEESED_TEST_PASSPHRASE":        "from-env",
+		"file:" + tempFile("from-file\n", t): "from-file",
+		"exec:echo from-exec":                "from-exec",
+		`exec:printf '%s\n' 'tls/my key'`:    "tls/my key",
+	}
+
+	for source, expected := range sources {
+		passphrase, err := Passphrase(source)
+		if err != nil {
+			t.Fatalf("Error resolving %s: %s", source, err.Error())
+		}
+
+		if string(passphrase) != expected {
+			t.Fatalf("%s resolved to %q", source, passphrase)
+		}
+	}
+
+	for _, source := range []string{"env:CHEESED_TEST_UNSET", "secret", "vault:foo", "exec:echo 'unterminated"} {
+		if _, err := Passphrase(source); err == nil {
+			t.Fatalf("Expected an error resolving %s", source)
+		}
+	}
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	cert, key, err := pki.GenerateCert([]string{"foo.example.com"}, pki.KeyTypeRSA, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	der, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Error encrypting pkcs8 key: %s", err.Error())
+	}
+
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey)), []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatalf("Error encrypting legacy key: %s", err.Error())
+	}
+
+	certFile := tempFile(string(pki.EncodeCertificate(cert)), t)
+	keys := map[string]string{
+		"pkcs8":  tempFile(string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})), t),
+		"legacy": tempFile(string(pem.EncodeToMemory(legacy)), t),
+	}
+
+	t.Setenv("CHEESED_TEST_PASSPHRASE", "secret")
+
+	for name, keyFile := range keys {
+		if _, err := loadCertificate(certFile+","+keyFile, nil); err == nil {
+			t.Fatalf("Expected an error loading the %s key without a passphrase", name)
+		}
+
+		if _, err := loadCertificate(certFile+","+keyFile+",passphrase=exec:echo wrong", nil); err == nil {
+			t.Fatalf("Expected an error loading the %s key with the wrong passphrase", name)
+		}
+
+		if _, err := loadCertificate(certFile+","+keyFile+",passphrase=env:CHEESED_TEST_PASSPHRASE", nil); err != nil {
+			t.Fatalf("Error loading the %s key: %s", name, err.Error())
+		}
+	}
+
+	SetPassphrase("env:CHEESED_TEST_PASSPHRASE")
+	defer SetPassphrase("")
+
+	if _, err := LoadX509KeyPair(certFile, keys["pkcs8"]); err != nil {
+		t.Fatalf("Error loading key with the global passphrase: %s", err.Error())
+	}
+}
@@ -0,0 +1,52 @@
+package sni
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func isBundle(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".p12", ".pfx":
+		return true
+	}
+
+	return false
+}
+
+// loadBundle extracts the leaf, chain and key from a PKCS#12 bundle. Bundles
+// without a configured passphrase are opened with an empty password.
+func loadBundle(path, source string) (*tls.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var password []byte
+	if source != "" || defaultPassphrase != "" {
+		if password, err = passphrase(source); err != nil {
+			return nil, err
+		}
+	}
+
+	key, leaf, chain, err := pkcs12.DecodeChain(data, string(password))
+	if err != nil {
+		return nil, Error{message: "Error decoding " + path + ": " + err.Error()}
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+
+	for _, ca := range chain {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,64 @@
+package sni
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestPKCS12Bundle(t *testing.T) {
+	ca, caKey, err := pki.GenerateCA("ca.example.com", pki.KeyTypeECDSA, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generating CA: %s", err.Error())
+	}
+
+	cert, key, err := pki.GenerateCert([]string{"foo.example.com"}, pki.KeyTypeECDSA, time.Hour, ca, caKey)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	data, err := pkcs12.Modern.Encode(key, cert, []*x509.Certificate{ca}, "secret")
+	if err != nil {
+		t.Fatalf("Error encoding bundle: %s", err.Error())
+	}
+
+	bundle := filepath.Join(t.TempDir(), "foo.pfx")
+	if err = ioutil.WriteFile(bundle, data, 0600); err != nil {
+		t.Fatalf("Error writing bundle: %s", err.Error())
+	}
+
+	if _, err = loadCertificate(bundle, nil); err == nil {
+		t.Fatal("Expected an error loading the bundle without a passphrase")
+	}
+
+	t.Setenv("CHEESED_TEST_PASSPHRASE", "secret")
+
+	adapter, err := NewInMemoryAdapter(map[string]string{
+		"foo.example.com": bundle + ",passphrase=env:CHEESED_TEST_PASSPHRASE",
+	})
+	if err != nil {
+		t.Fatalf("Error creating an in memory adapter: %s", err.Error())
+	}
+
+	loaded, err := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"})
+	if err != nil || loaded == nil {
+		t.Fatalf("No certificate for foo.example.com: %v", err)
+	}
+
+	if len(loaded.Certificate) != 2 || loaded.Leaf.Subject.CommonName != "foo.example.com" {
+		t.Fatal("Bundle leaf and chain were not extracted")
+	}
+
+	SetPassphrase("env:CHEESED_TEST_PASSPHRASE")
+	defer SetPassphrase("")
+
+	if _, err = LoadX509KeyPair(bundle, ""); err != nil {
+		t.Fatalf("Error loading the bundle as a default certificate: %s", err.Error())
+	}
+}
@@ -0,0 +1,210 @@
+package sni
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretsAdapter serves certificates from Kubernetes TLS secrets mounted
+// under a directory, one secret per subdirectory:
+//
+//	<dir>/<secret>/tls.crt
+//	<dir>/<secret>/tls.key
+//
+// Certificates are mapped to host names by their SANs. Kubernetes updates a
+// mounted secret by swapping its ..data symlink; the adapter polls for swaps
+// and replaces its table in one step. Secrets that do not hold a valid key
+// pair are skipped and logged.
+type SecretsAdapter struct {
+	dir      string
+	versions map[string]string
+	table    map[string]*tls.Certificate
+	lock     sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	secretsRefresh = 5 * time.Second
+)
+
+func NewSecretsAdapter(config map[string]string) (Adapter, error) {
+	adapter := &SecretsAdapter{dir: config["dir"], stop: make(chan struct{})}
+	if adapter.dir == "" {
+		return nil, Error{message: "secrets adapter requires a dir."}
+	}
+
+	refresh := secretsRefresh
+	if s, ok := config["refresh"]; ok {
+		var err error
+
+		if refresh, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := adapter.Reload(); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go adapter.watch(refresh)
+	}
+
+	return adapter, nil
+}
+
+func (adp *SecretsAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	return lookup(adp.table, hello.ServerName), nil
+}
+
+func (adp *SecretsAdapter) Certificates() map[string]*tls.Certificate {
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	return copyTable(adp.table)
+}
+
+func (adp *SecretsAdapter) Reload() error {
+	versions, err := adp.scan()
+	if err != nil {
+		return err
+	}
+
+	table := make(map[string]*tls.Certificate)
+
+	for secret := range versions {
+		base := filepath.Join(adp.dir, secret)
+
+		cert, err := tls.LoadX509KeyPair(filepath.Join(base, "tls.crt"), filepath.Join(base, "tls.key"))
+		if err == nil && cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		}
+
+		if err != nil {
+			logger.Printf("secrets adapter: skipping %s: %s", secret, err.Error())
+			continue
+		}
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+
+		for _, name := range names {
+			table[strings.ToLower(name)] = &cert
+		}
+	}
+
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	adp.table = table
+	adp.versions = versions
+	return nil
+}
+
+// scan returns the TLS secrets under dir with the target of each secret's
+// ..data symlink, or the modification time of its certificate when the
+// secret is a plain directory.
+func (adp *SecretsAdapter) scan() (map[string]string, error) {
+	entries, err := ioutil.ReadDir(adp.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]string)
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		base := filepath.Join(adp.dir, entry.Name())
+
+		info, err := os.Stat(filepath.Join(base, "tls.crt"))
+		if err != nil {
+			continue
+		}
+
+		if target, err := os.Readlink(filepath.Join(base, "..data")); err == nil {
+			versions[entry.Name()] = target
+		} else {
+			versions[entry.Name()] = info.ModTime().String()
+		}
+	}
+
+	return versions, nil
+}
+
+func (adp *SecretsAdapter) changed() (bool, error) {
+	versions, err := adp.scan()
+	if err != nil {
+		return false, err
+	}
+
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	if len(versions) != len(adp.versions) {
+		return true, nil
+	}
+
+	for secret, version := range versions {
+		if adp.versions[secret] != version {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (adp *SecretsAdapter) Close() error {
+	adp.stopOnce.Do(func() {
+		close(adp.stop)
+	})
+
+	return nil
+}
+
+func (adp *SecretsAdapter) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := adp.changed()
+			if err == nil && changed {
+				err = adp.Reload()
+			}
+
+			if err != nil {
+				logger.Printf("secrets adapter: reload failed: %s", err.Error())
+			}
+		case <-adp.stop:
+			return
+		}
+	}
+}
+
+var _ = Register("secrets", func(config map[string]string) (Adapter, error) {
+	return NewSecretsAdapter(config)
+})
+
+var _ = RegisterValidator("secrets", settings{
+	known: map[string]func(string) error{
+		"dir":     nil,
+		"refresh": CheckDuration,
+	},
+	required: []string{"dir"},
+}.validate)
@@ -0,0 +1,99 @@
+package sni
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestSecretsAdapter(t *testing.T) {
+	dir := t.TempDir()
+
+	mountSecret(dir, "web", "..v1", []string{"foo.example.com", "*.wild.example.com"}, t)
+
+	// a broken secret is skipped rather than failing the others
+	os.MkdirAll(filepath.Join(dir, "broken"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "broken", "tls.crt"), []byte("not a certificate"), 0600)
+
+	adapter, err := NewAdapter("secrets", map[string]string{"dir": dir, "refresh": "10ms"})
+	if err != nil {
+		t.Fatalf("Error creating a secrets adapter: %s", err.Error())
+	}
+
+	for _, name := range []string{"foo.example.com", "a.wild.example.com"} {
+		if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: name}); cert == nil {
+			t.Fatalf("No certificate for %s", name)
+		}
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "bar.example.com"}); cert != nil {
+		t.Fatal("Unexpected certificate for bar.example.com")
+	}
+
+	mountSecret(dir, "web", "..v2", []string{"bar.example.com"}, t)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "bar.example.com"})
+		if cert != nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Symlink swap was not picked up")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert != nil {
+		t.Fatal("Certificate for foo.example.com survived the swap")
+	}
+
+	if err = adapter.(Closer).Close(); err != nil {
+		t.Fatalf("Error closing the secrets adapter: %s", err.Error())
+	}
+
+	select {
+	case <-adapter.(*SecretsAdapter).stop:
+	default:
+		t.Fatal("Close did not stop the watch")
+	}
+}
+
+// mountSecret lays out a secret the way the kubelet does, with the files in a
+// versioned directory published through the ..data symlink.
+func mountSecret(dir, secret, version string, hostnames []string, t *testing.T) {
+	base := filepath.Join(dir, secret)
+
+	if err := os.MkdirAll(filepath.Join(base, version), 0700); err != nil {
+		t.Fatalf("Error creating secret: %s", err.Error())
+	}
+
+	cert, key, err := pki.GenerateCert(hostnames, pki.KeyTypeECDSA, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+	ioutil.WriteFile(filepath.Join(base, version, "tls.crt"), pki.EncodeCertificate(cert), 0600)
+	ioutil.WriteFile(filepath.Join(base, version, "tls.key"), keyPEM, 0600)
+
+	tmp := filepath.Join(base, "..data_tmp")
+	if err = os.Symlink(version, tmp); err != nil {
+		t.Fatalf("Error linking secret: %s", err.Error())
+	}
+
+	if err = os.Rename(tmp, filepath.Join(base, "..data")); err != nil {
+		t.Fatalf("Error swapping secret: %s", err.Error())
+	}
+
+	for _, file := range []string{"tls.crt", "tls.key"} {
+		os.Symlink(filepath.Join("..data", file), filepath.Join(base, file))
+	}
+}
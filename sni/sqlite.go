@@ -0,0 +1,154 @@
+package sni
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteAdapter serves certificates stored in a SQLite database:
+//
+//	CREATE TABLE certificates (
+//		servername TEXT PRIMARY KEY, -- host name, or *.example.com for a wildcard
+//		chain      TEXT NOT NULL,    -- PEM leaf certificate followed by intermediates
+//		key        TEXT NOT NULL     -- PEM private key
+//	);
+//
+// The table is loaded at startup, on Reload and every refresh interval. Rows
+// that do not hold a valid key pair are skipped and logged.
+type SQLiteAdapter struct {
+	db       *sql.DB
+	keys     KeySources
+	table    map[string]*tls.Certificate
+	lock     sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	sqliteQuery = "SELECT servername, chain, key FROM certificates"
+)
+
+func NewSQLiteAdapter(config map[string]string) (Adapter, error) {
+	path := config["database"]
+	if path == "" {
+		return nil, Error{message: "sqlite adapter requires a database."}
+	}
+
+	var refresh time.Duration
+	if s, ok := config["refresh"]; ok {
+		var err error
+
+		if refresh, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	adapter := &SQLiteAdapter{db: db, keys: NewKeySources(config), stop: make(chan struct{})}
+
+	if err = adapter.Reload(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go adapter.refresh(refresh)
+	}
+
+	return adapter, nil
+}
+
+func (adp *SQLiteAdapter) Callback(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	return lookup(adp.table, hello.ServerName), nil
+}
+
+func (adp *SQLiteAdapter) Certificates() map[string]*tls.Certificate {
+	adp.lock.RLock()
+	defer adp.lock.RUnlock()
+
+	return copyTable(adp.table)
+}
+
+func (adp *SQLiteAdapter) Reload() error {
+	rows, err := adp.db.Query(sqliteQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	table := make(map[string]*tls.Certificate)
+
+	for rows.Next() {
+		var servername, chain, key string
+
+		if err = rows.Scan(&servername, &chain, &key); err != nil {
+			return err
+		}
+
+		cert, err := adp.keys.X509KeyPair([]byte(chain), []byte(key))
+		if err != nil {
+			logger.Printf("sqlite adapter: skipping %s: %s", servername, err.Error())
+			continue
+		}
+
+		table[strings.ToLower(servername)] = &cert
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	adp.table = table
+	return nil
+}
+
+func (adp *SQLiteAdapter) Close() error {
+	adp.stopOnce.Do(func() {
+		close(adp.stop)
+	})
+
+	return adp.db.Close()
+}
+
+func (adp *SQLiteAdapter) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := adp.Reload(); err != nil {
+				logger.Printf("sqlite adapter: reload failed: %s", err.Error())
+			}
+		case <-adp.stop:
+			return
+		}
+	}
+}
+
+var _ = Register("sqlite", func(config map[string]string) (Adapter, error) {
+	return NewSQLiteAdapter(config)
+})
+
+var _ = RegisterValidator("sqlite", settings{
+	known: map[string]func(string) error{
+		"database": nil,
+		"refresh":  CheckDuration,
+	},
+	required: []string{"database"},
+}.validate)
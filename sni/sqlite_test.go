@@ -0,0 +1,109 @@
+package sni
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/cheeseman/pki"
+)
+
+func TestSQLiteAdapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+
+	path := filepath.Join(dir, "certs.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE certificates (servername TEXT PRIMARY KEY, chain TEXT NOT NULL, key TEXT NOT NULL)")
+	if err != nil {
+		t.Fatalf("Error creating schema: %s", err.Error())
+	}
+
+	insertCertificate(db, "foo.example.com", t)
+	insertCertificate(db, "*.wild.example.com", t)
+
+	adapter, err := NewAdapter("sqlite", map[string]string{"database": path})
+	if err != nil {
+		t.Fatalf("Error creating a sqlite adapter: %s", err.Error())
+	}
+
+	for _, name := range []string{"Foo.example.com", "a.wild.example.com"} {
+		if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: name}); cert == nil {
+			t.Fatalf("No certificate was found for %s", name)
+		}
+	}
+
+	for _, name := range []string{"bar.example.com", "a.b.wild.example.com", "wild.example.com"} {
+		if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: name}); cert != nil {
+			t.Fatalf("An invalid certificate was returned for %s", name)
+		}
+	}
+
+	insertCertificate(db, "bar.example.com", t)
+
+	if err = adapter.(Reloader).Reload(); err != nil {
+		t.Fatalf("Error reloading the sqlite adapter: %s", err.Error())
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "bar.example.com"}); cert == nil {
+		t.Fatal("Reload did not pick up a new row")
+	}
+
+	_, err = db.Exec("INSERT INTO certificates (servername, chain, key) VALUES ('bad.example.com', 'garbage', 'garbage')")
+	if err != nil {
+		t.Fatalf("Error inserting a bad row: %s", err.Error())
+	}
+
+	if err = adapter.(Reloader).Reload(); err != nil {
+		t.Fatalf("A bad row failed the reload: %s", err.Error())
+	}
+
+	if cert, _ := adapter.Callback(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); cert == nil {
+		t.Fatal("A bad row dropped the other certificates")
+	}
+
+	if _, err = NewAdapter("sqlite", map[string]string{"database": path, "refresh": "soon"}); err == nil {
+		t.Fatal("NewSQLiteAdapter did not catch an invalid refresh interval")
+	}
+
+	refreshed, err := NewAdapter("sqlite", map[string]string{"database": path, "refresh": "10ms"})
+	if err != nil {
+		t.Fatalf("Error creating a sqlite adapter: %s", err.Error())
+	}
+
+	if err = refreshed.(Closer).Close(); err != nil {
+		t.Fatalf("Error closing the sqlite adapter: %s", err.Error())
+	}
+
+	select {
+	case <-refreshed.(*SQLiteAdapter).stop:
+	default:
+		t.Fatal("Close did not stop the refresh")
+	}
+}
+
+func insertCertificate(db *sql.DB, servername string, t *testing.T) {
+	cert, key, err := pki.GenerateCert([]string{servername}, pki.KeyTypeECDSA, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %s", err.Error())
+	}
+
+	keyPEM, _ := pki.EncodeKey(key)
+
+	_, err = db.Exec("INSERT INTO certificates (servername, chain, key) VALUES (?, ?, ?)",
+		servername, string(pki.EncodeCertificate(cert)), string(keyPEM))
+	if err != nil {
+		t.Fatalf("Error inserting certificate: %s", err.Error())
+	}
+}
@@ -0,0 +1,193 @@
+package sni
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyError is a problem with one adapter setting. An empty Key refers to the
+// adapter as a whole. Errors from composed adapters carry an "<adapter>."
+// key prefix, as in the composite adapter's config, or the bare adapter name
+// when they concern the whole adapter.
+type KeyError struct {
+	Key     string
+	Message string
+}
+
+// Validator checks an adapter's settings without creating the adapter.
+type Validator func(config map[string]string) []error
+
+// settings describes the keys an adapter accepts. A nil check accepts any
+// value. composes lists the keys that name composed adapters.
+type settings struct {
+	known    map[string]func(string) error
+	required []string
+	composes []string
+}
+
+var (
+	validators = make(map[string]Validator)
+)
+
+func RegisterValidator(name string, validator Validator) error {
+	validators[name] = validator
+
+	return nil
+}
+
+// Registered reports whether name is a registered adapter.
+func Registered(name string) bool {
+	_, ok := registry[name]
+	return ok
+}
+
+// Validate checks config against the named adapter's validator. Adapters that
+// do not register one accept any settings.
+func Validate(name string, config map[string]string) []error {
+	if !Registered(name) {
+		return []error{KeyError{Message: name + " is not a registered adapter"}}
+	}
+
+	validator, ok := validators[name]
+	if !ok {
+		return nil
+	}
+
+	return validator(config)
+}
+
+func (err KeyError) Error() string {
+	if err.Key == "" {
+		return err.Message
+	}
+
+	return err.Key + ": " + err.Message
+}
+
+func (s settings) validate(config map[string]string) (errs []error) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var composed []string
+	for _, key := range s.composes {
+		for _, name := range splitList(config[key]) {
+			composed = append(composed, strings.ToLower(name))
+		}
+	}
+
+	for _, key := range keys {
+		if subKey(composed, key) {
+			continue
+		}
+
+		if isKeySetting(key) {
+			if err := checkKeySetting(key, config[key]); err != nil {
+				errs = append(errs, KeyError{Key: key, Message: err.Error()})
+			}
+
+			continue
+		}
+
+		check, ok := s.known[key]
+		if !ok {
+			errs = append(errs, KeyError{Key: key, Message: "unknown setting"})
+			continue
+		}
+
+		if check == nil {
+			continue
+		}
+
+		if err := check(config[key]); err != nil {
+			errs = append(errs, KeyError{Key: key, Message: err.Error()})
+		}
+	}
+
+	for _, key := range s.required {
+		if strings.TrimSpace(config[key]) == "" {
+			errs = append(errs, KeyError{Key: key, Message: "a value is required"})
+		}
+	}
+
+	for _, name := range composed {
+		for _, err := range Validate(name, SubConfig(config, name)) {
+			errs = append(errs, prefixKey(name, err))
+		}
+	}
+
+	return errs
+}
+
+func subKey(composed []string, key string) bool {
+	for _, name := range composed {
+		if strings.HasPrefix(key, name+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func prefixKey(name string, err error) error {
+	keyErr, ok := err.(KeyError)
+	if !ok {
+		return KeyError{Key: name, Message: err.Error()}
+	}
+
+	if keyErr.Key == "" {
+		return KeyError{Key: name, Message: keyErr.Message}
+	}
+
+	return KeyError{Key: name + "." + keyErr.Key, Message: keyErr.Message}
+}
+
+// CheckDuration checks that s is a duration such as 30s or 5m.
+func CheckDuration(s string) error {
+	_, err := time.ParseDuration(s)
+	return err
+}
+
+// checkKeySetting checks the key settings every adapter accepts, see
+// NewKeySources.
+func checkKeySetting(key, value string) error {
+	if key == "signertimeout" {
+		return CheckDuration(value)
+	}
+
+	return nil
+}
+
+func checkCount(s string) error {
+	if n, err := strconv.Atoi(s); err != nil || n < 1 {
+		return Error{message: "must be a positive number"}
+	}
+
+	return nil
+}
+
+func checkURL(s string) error {
+	_, err := url.Parse(s)
+	return err
+}
+
+// CheckOneOf returns a check accepting any of values, ignoring case and
+// surrounding space.
+func CheckOneOf(values ...string) func(string) error {
+	return func(s string) error {
+		s = strings.TrimSpace(s)
+
+		for _, value := range values {
+			if strings.EqualFold(s, value) {
+				return nil
+			}
+		}
+
+		return Error{message: "must be one of " + strings.Join(values, ", ")}
+	}
+}
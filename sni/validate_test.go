@@ -0,0 +1,62 @@
+package sni
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if errs := Validate("sqlite", map[string]string{"database": "/var/lib/certs.db", "refresh": "1m"}); len(errs) != 0 {
+		t.Fatalf("Valid sqlite settings were rejected: %v", errs)
+	}
+
+	assertKeyErrors(Validate("sqlite", map[string]string{"databse": "/var/lib/certs.db", "refresh": "soon"}), t,
+		"database: a value is required", "databse: unknown setting", "refresh: time: invalid duration")
+
+	assertKeyErrors(Validate("chain", map[string]string{
+		"adapters":                 "inmemory, cache",
+		"inmemory.foo.example.com": "",
+		"inmemory.foo example.com": "/etc/foo.pem",
+		"cache.adapter":            "exec",
+		"cache.ttl":                "1m",
+		"cache.exec.timeout":       "later",
+	}), t,
+		"cache.exec.command: a value is required",
+		"cache.exec.timeout: time: invalid duration",
+		"inmemory.foo example.com: is not a host name",
+		"inmemory.foo.example.com: a value is required")
+
+	assertKeyErrors(Validate("cache", map[string]string{"adapter": "nonexistent"}), t,
+		"nonexistent: nonexistent is not a registered adapter")
+
+	if errs := Validate("nonexistent", nil); len(errs) != 1 {
+		t.Fatalf("Validate did not catch an unregistered adapter: %v", errs)
+	}
+}
+
+func assertKeyErrors(errs []error, t *testing.T, expected ...string) {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		if _, ok := err.(KeyError); !ok {
+			t.Fatalf("%v is not a KeyError", err)
+		}
+
+		messages[i] = err.Error()
+	}
+
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d errors, got %q", len(expected), messages)
+	}
+
+	for _, prefix := range expected {
+		found := false
+
+		for _, message := range messages {
+			found = found || strings.HasPrefix(message, prefix)
+		}
+
+		if !found {
+			t.Fatalf("Missing error %q in %q", prefix, messages)
+		}
+	}
+}
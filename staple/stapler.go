@@ -0,0 +1,423 @@
+package staple
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type Error struct {
+	message string
+}
+
+type Stapler struct {
+	CacheDir   string
+	Responder  string
+	MustStaple bool
+	Client     *http.Client
+	Log        *log.Logger
+
+	entries map[string]*entry
+	lock    sync.Mutex
+}
+
+type entry struct {
+	key    string
+	cert   *tls.Certificate
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+
+	tracked    bool
+	stapled    *tls.Certificate
+	response   *ocsp.Response
+	used       time.Time
+	refreshing bool
+	lock       sync.Mutex
+}
+
+var (
+	oidTLSFeature       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	statusRequest       = 5
+	refreshInterval     = time.Minute
+	idleTimeout         = 24 * time.Hour
+	responderTimeout    = 10 * time.Second
+	ocspRequestMimeType = "application/ocsp-request"
+)
+
+func NewStapler(cacheDir, responder string, mustStaple bool) *Stapler {
+	return &Stapler{
+		CacheDir:   cacheDir,
+		Responder:  responder,
+		MustStaple: mustStaple,
+		Client:     &http.Client{Timeout: responderTimeout},
+		entries:    make(map[string]*entry),
+	}
+}
+
+// Track sets the loaded certificates and fetches responses for those without
+// a fresh one. Certificates that are not tracked are dropped once they have
+// not been served for a day.
+func (st *Stapler) Track(certs []*tls.Certificate) {
+	tracked := make(map[string]bool)
+
+	for _, cert := range certs {
+		if len(cert.Certificate) == 0 {
+			continue
+		}
+
+		ent, err := st.entry(cert)
+		if err != nil {
+			st.logf("OCSP stapling disabled for a certificate: %s", err.Error())
+			continue
+		}
+
+		tracked[ent.key] = true
+	}
+
+	st.lock.Lock()
+	for key, ent := range st.entries {
+		ent.lock.Lock()
+		ent.tracked = tracked[key]
+		ent.lock.Unlock()
+	}
+	st.lock.Unlock()
+
+	st.refreshAll()
+}
+
+// Staple returns cert with the cached OCSP response. It never contacts a
+// responder; certificates seen for the first time are fetched in the
+// background.
+func (st *Stapler) Staple(cert *tls.Certificate) (*tls.Certificate, error) {
+	if len(cert.Certificate) == 0 {
+		return cert, nil
+	}
+
+	ent, err := st.entry(cert)
+	if err != nil {
+		return st.unstapled(cert, nil, err)
+	}
+
+	now := time.Now()
+
+	ent.lock.Lock()
+	first := ent.used.IsZero() && !ent.tracked
+	ent.used = now
+	stapled, valid := ent.stapled, ent.valid(now)
+	ent.lock.Unlock()
+
+	if first && !valid {
+		go st.refresh(ent)
+	}
+
+	if !valid {
+		return st.unstapled(cert, ent, newError("No valid OCSP response for "+ent.leaf.Subject.CommonName))
+	}
+
+	return stapled, nil
+}
+
+func (st *Stapler) Run(done <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(refreshInterval):
+			st.refreshAll()
+		case <-done:
+			return
+		}
+	}
+}
+
+// entry finds or creates the entry for cert. New entries start from the disk
+// cache.
+func (st *Stapler) entry(cert *tls.Certificate) (*entry, error) {
+	key := fingerprint(cert.Certificate[0])
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if ent, ok := st.entries[key]; ok {
+		return ent, nil
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ent := &entry{key: key, cert: cert, leaf: leaf}
+
+	if len(cert.Certificate) > 1 {
+		ent.issuer, err = x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if raw, response, err := st.loadCache(ent); err == nil {
+		ent.set(raw, response)
+	}
+
+	st.entries[key] = ent
+	return ent, nil
+}
+
+func (st *Stapler) refreshAll() {
+	now := time.Now()
+
+	st.lock.Lock()
+	entries := make([]*entry, 0, len(st.entries))
+	for key, ent := range st.entries {
+		ent.lock.Lock()
+		idle := !ent.tracked && now.Sub(ent.used) > idleTimeout
+		ent.lock.Unlock()
+
+		if idle {
+			delete(st.entries, key)
+			continue
+		}
+
+		entries = append(entries, ent)
+	}
+	st.lock.Unlock()
+
+	for _, ent := range entries {
+		ent.lock.Lock()
+		due := ent.response == nil || !now.Before(refreshAt(ent.response))
+		ent.lock.Unlock()
+
+		if !due {
+			continue
+		}
+
+		if err := st.refresh(ent); err != nil {
+			st.logf("OCSP refresh for %s failed: %s", ent.leaf.Subject.CommonName, err.Error())
+		}
+	}
+}
+
+// refresh fetches a new response for ent when its current one is due. The
+// entry is only locked to swap the response, so handshakes never wait on a
+// responder.
+func (st *Stapler) refresh(ent *entry) error {
+	ent.lock.Lock()
+	if ent.refreshing {
+		ent.lock.Unlock()
+		return nil
+	}
+
+	ent.refreshing = true
+	response := ent.response
+	ent.lock.Unlock()
+
+	defer func() {
+		ent.lock.Lock()
+		ent.refreshing = false
+		ent.lock.Unlock()
+	}()
+
+	if ent.issuer == nil {
+		return newError("No issuer certificate in chain for " + ent.leaf.Subject.CommonName)
+	}
+
+	if response != nil && time.Now().Before(refreshAt(response)) {
+		return nil
+	}
+
+	raw, fetched, err := st.fetch(ent)
+	if err != nil {
+		return err
+	}
+
+	st.storeCache(ent, raw)
+	ent.set(raw, fetched)
+
+	return nil
+}
+
+func (st *Stapler) fetch(ent *entry) ([]byte, *ocsp.Response, error) {
+	responder := st.Responder
+	if responder == "" {
+		if len(ent.leaf.OCSPServer) == 0 {
+			return nil, nil, newError("No OCSP responder for " + ent.leaf.Subject.CommonName)
+		}
+
+		responder = ent.leaf.OCSPServer[0]
+	}
+
+	request, err := ocsp.CreateRequest(ent.leaf, ent.issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := st.Client.Post(responder, ocspRequestMimeType, bytes.NewReader(request))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, newError("OCSP responder " + responder + " returned " + resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := parse(raw, ent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return raw, response, nil
+}
+
+func (st *Stapler) loadCache(ent *entry) ([]byte, *ocsp.Response, error) {
+	if st.CacheDir == "" {
+		return nil, nil, newError("No OCSP cache directory")
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(st.CacheDir, ent.key+".ocsp"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := parse(raw, ent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return raw, response, nil
+}
+
+func (st *Stapler) storeCache(ent *entry, raw []byte) {
+	if st.CacheDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(st.CacheDir, 0700); err != nil {
+		return
+	}
+
+	path := filepath.Join(st.CacheDir, ent.key+".ocsp")
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return
+	}
+
+	os.Rename(tmp, path)
+}
+
+func (st *Stapler) unstapled(cert *tls.Certificate, ent *entry, err error) (*tls.Certificate, error) {
+	if !st.MustStaple {
+		return cert, nil
+	}
+
+	leaf := cert.Leaf
+	if ent != nil {
+		leaf = ent.leaf
+	}
+
+	if leaf != nil && MustStaple(leaf) {
+		return nil, newError("Refusing to serve Must-Staple certificate without a valid staple: " + err.Error())
+	}
+
+	return cert, nil
+}
+
+func (ent *entry) set(raw []byte, response *ocsp.Response) {
+	stapled := *ent.cert
+	stapled.OCSPStaple = raw
+
+	ent.lock.Lock()
+	defer ent.lock.Unlock()
+
+	ent.response = response
+	ent.stapled = &stapled
+}
+
+func (ent *entry) valid(now time.Time) bool {
+	return ent.response != nil && now.Before(ent.response.NextUpdate)
+}
+
+func (st *Stapler) logf(format string, v ...interface{}) {
+	if st.Log != nil {
+		st.Log.Printf(format, v...)
+		return
+	}
+
+	log.Printf(format, v...)
+}
+
+func MustStaple(leaf *x509.Certificate) bool {
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+
+		for _, feature := range features {
+			if feature == statusRequest {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func parse(raw []byte, ent *entry) (*ocsp.Response, error) {
+	response, err := ocsp.ParseResponseForCert(raw, ent.leaf, ent.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Status != ocsp.Good {
+		return nil, newError("OCSP status for " + ent.leaf.Subject.CommonName + " is not good")
+	}
+
+	if !time.Now().Before(response.NextUpdate) {
+		return nil, newError("OCSP response for " + ent.leaf.Subject.CommonName + " is expired")
+	}
+
+	return response, nil
+}
+
+func refreshAt(response *ocsp.Response) time.Time {
+	if response == nil {
+		return time.Time{}
+	}
+
+	return response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func newError(message string) error {
+	return Error{message: message}
+}
+
+func (err Error) Error() string {
+	return err.message
+}
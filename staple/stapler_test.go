@@ -0,0 +1,217 @@
+package staple
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestStaple(t *testing.T) {
+	cert, issuer, issuerKey := testChain(false, t)
+	responder, requests := testResponder(issuer, issuerKey, ocsp.Good)
+	defer responder.Close()
+
+	cacheDir := tempDir(t)
+
+	stapler := NewStapler(cacheDir, responder.URL, false)
+	stapler.Track([]*tls.Certificate{cert})
+
+	stapled, err := stapler.Staple(cert)
+	if err != nil {
+		t.Fatalf("Error stapling certificate: %s", err.Error())
+	}
+
+	if len(stapled.OCSPStaple) == 0 {
+		t.Fatal("Certificate was not stapled")
+	}
+
+	if len(cert.OCSPStaple) != 0 {
+		t.Fatal("Stapling modified the adapter's certificate")
+	}
+
+	stapler.Staple(cert)
+	if *requests != 1 {
+		t.Fatalf("Expected 1 OCSP request, got %d", *requests)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "*.ocsp"))
+	if len(files) != 1 {
+		t.Fatal("OCSP response was not cached on disk")
+	}
+
+	cached := NewStapler(cacheDir, "http://127.0.0.1:1/", false)
+
+	stapled, err = cached.Staple(cert)
+	if err != nil || len(stapled.OCSPStaple) == 0 {
+		t.Fatal("Cached OCSP response was not stapled")
+	}
+}
+
+func TestMustStaple(t *testing.T) {
+	cert, issuer, issuerKey := testChain(true, t)
+
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if !MustStaple(leaf) {
+		t.Fatal("Must-Staple extension was not detected")
+	}
+
+	responder, _ := testResponder(issuer, issuerKey, ocsp.Revoked)
+	defer responder.Close()
+
+	lenient := NewStapler("", responder.URL, false)
+
+	if _, err := lenient.Staple(cert); err != nil {
+		t.Fatalf("Stapler refused a certificate without enforcement: %s", err.Error())
+	}
+
+	strict := NewStapler("", responder.URL, true)
+
+	if _, err := strict.Staple(cert); err == nil {
+		t.Fatal("Stapler served a Must-Staple certificate without a valid staple")
+	}
+
+	plain, _, _ := testChain(false, t)
+
+	if _, err := strict.Staple(plain); err != nil {
+		t.Fatalf("Stapler refused a certificate without Must-Staple: %s", err.Error())
+	}
+}
+
+func TestStapleFromCache(t *testing.T) {
+	cert, _, _ := testChain(false, t)
+
+	blocked := make(chan struct{})
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer responder.Close()
+	defer close(blocked)
+
+	stapler := NewStapler("", responder.URL, false)
+
+	start := time.Now()
+	if _, err := stapler.Staple(cert); err != nil {
+		t.Fatalf("Error stapling certificate: %s", err.Error())
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("Staple waited on the OCSP responder")
+	}
+
+	tracked := NewStapler("", "http://127.0.0.1:1/", false)
+	tracked.Track([]*tls.Certificate{cert})
+	tracked.Track(nil)
+
+	if len(tracked.entries) != 0 {
+		t.Fatal("A certificate that is no longer loaded was not evicted")
+	}
+
+	strict := NewStapler("", "http://127.0.0.1:1/", true)
+	if _, err := strict.Staple(&tls.Certificate{Certificate: [][]byte{[]byte("not a certificate")}}); err != nil {
+		t.Fatalf("Stapler refused a certificate that is not known to be Must-Staple: %s", err.Error())
+	}
+}
+
+func testChain(mustStaple bool, t *testing.T) (*tls.Certificate, *x509.Certificate, *ecdsa.PrivateKey) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca.example.org"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating CA certificate: %s", err.Error())
+	}
+
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "foo.example.org"},
+		DNSNames:     []string{"foo.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	if mustStaple {
+		value, _ := asn1.Marshal([]int{statusRequest})
+		template.ExtraExtensions = []pkix.Extension{{Id: oidTLSFeature, Value: value}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err.Error())
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, caDER},
+		PrivateKey:  key,
+	}
+
+	return cert, ca, caKey
+}
+
+func testResponder(issuer *x509.Certificate, key *ecdsa.PrivateKey, status int) (*httptest.Server, *int) {
+	requests := new(int)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(resp)
+	}))
+
+	return srv, requests
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+
+	os.Remove(dir)
+
+	return dir
+}